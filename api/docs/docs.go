@@ -15,38 +15,76 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/subs": {
+        "/admin/api-keys": {
             "get": {
-                "description": "Get list of subscriptions by user_id. If user_id is empty returns all subs",
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "APIKeyAuth": []
+                    }
+                ],
+                "description": "Get every API key, revoked ones included, oldest first. The keys themselves are not stored and cannot be listed",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "subscriptions"
-                ],
-                "summary": "Get list of subscriptions",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "User ID (optional)",
-                        "name": "user_id",
-                        "in": "query"
-                    }
+                    "api keys"
                 ],
+                "summary": "List API keys",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/model.Subscription"
+                                "$ref": "#/definitions/model.APIKey"
                             }
                         }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "Request cancelled",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
                     }
                 }
             },
             "post": {
-                "description": "Create a new subscription record",
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "APIKeyAuth": []
+                    }
+                ],
+                "description": "Create an API key for a service account. The key is only returned in this response, pass it in the X-API-Key header. Roles: reader reads and aggregates every user's subscriptions, writer also changes them, admin also manages currency rates and API keys",
                 "consumes": [
                     "application/json"
                 ],
@@ -54,17 +92,17 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "subscriptions"
+                    "api keys"
                 ],
-                "summary": "Create subscription",
+                "summary": "Create API key",
                 "parameters": [
                     {
-                        "description": "Subscription object",
-                        "name": "subscription",
+                        "description": "API key",
+                        "name": "key",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/model.Subscription"
+                            "$ref": "#/definitions/router.CreateAPIKeyRequest"
                         }
                     }
                 ],
@@ -72,137 +110,208 @@ const docTemplate = `{
                     "201": {
                         "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/model.Subscription"
+                            "$ref": "#/definitions/router.CreatedAPIKey"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "Request cancelled",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
                         }
                     }
                 }
             }
         },
-        "/subs/aggregate": {
-            "get": {
-                "description": "Sum prices between dates, optional filters user_id \u0026 service_name",
-                "produces": [
-                    "application/json"
+        "/admin/api-keys/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "APIKeyAuth": []
+                    }
                 ],
+                "description": "Revoke an API key by its id, requests made with it are rejected from then on",
                 "tags": [
-                    "subscriptions"
+                    "api keys"
                 ],
-                "summary": "Aggregate subscriptions cost",
+                "summary": "Revoke API key",
                 "parameters": [
                     {
                         "type": "string",
-                        "example": "2025-01",
-                        "description": "Start month-year",
-                        "name": "from",
-                        "in": "query",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "example": "2025-07",
-                        "description": "End month-year",
-                        "name": "to",
-                        "in": "query",
+                        "description": "API key ID",
+                        "name": "id",
+                        "in": "path",
                         "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "User ID (optional)",
-                        "name": "user_id",
-                        "in": "query"
-                    },
-                    {
-                        "type": "string",
-                        "description": "Service name(optional)",
-                        "name": "service_name",
-                        "in": "query"
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "204": {
+                        "description": "No Content",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "integer"
-                            }
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "Request cancelled",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
                         }
                     }
                 }
             }
         },
-        "/subs/{id}": {
+        "/admin/currency-rates": {
             "get": {
-                "description": "Get subscription by its id",
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "subscriptions"
-                ],
-                "summary": "Get subscription",
-                "parameters": [
+                "security": [
                     {
-                        "type": "string",
-                        "description": "Subscription id",
-                        "name": "id",
-                        "in": "query",
-                        "required": true
+                        "BearerAuth": []
                     },
                     {
-                        "description": "Subscription object",
-                        "name": "subscription",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/model.Subscription"
-                        }
+                        "APIKeyAuth": []
                     }
                 ],
-                "responses": {
-                    "200": {
-                        "description": "OK",
-                        "schema": {
-                            "$ref": "#/definitions/model.Subscription"
-                        }
-                    }
-                }
-            },
-            "delete": {
-                "description": "Delete subscription by its id",
+                "description": "Get the stored exchange rates ordered by currency and month",
                 "produces": [
-                    "text/plain"
+                    "application/json"
                 ],
                 "tags": [
-                    "subscriptions"
+                    "currency rates"
                 ],
-                "summary": "Delete subscription",
+                "summary": "List currency rates",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Subscription ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Only rates of the currency (optional)",
+                        "name": "currency",
+                        "in": "query"
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.CurrencyRate"
+                            }
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "Request cancelled",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
                         }
                     }
                 }
             },
-            "patch": {
-                "description": "Update subscription by its id",
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "APIKeyAuth": []
+                    }
+                ],
+                "description": "Store monthly exchange rates used to convert aggregates, replacing the rates of the same currency and month. A rate is the price of one unit of the currency in RUB",
                 "consumes": [
                     "application/json"
                 ],
@@ -210,77 +319,1604 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "subscriptions"
+                    "currency rates"
                 ],
-                "summary": "Update subscription",
+                "summary": "Set currency rates",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Subscription ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "description": "UpdateSubscription object",
-                        "name": "subscription",
+                        "description": "Rates",
+                        "name": "rates",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/model.UpdateSubscription"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.CurrencyRate"
+                            }
                         }
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "204": {
+                        "description": "No Content",
                         "schema": {
-                            "$ref": "#/definitions/model.Subscription"
+                            "type": "string"
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/router.Problem"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "Request cancelled",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "model.Subscription": {
-            "type": "object",
-            "properties": {
-                "end_date": {
-                    "type": "string"
-                },
-                "id": {
-                    "type": "string"
-                },
-                "price": {
-                    "type": "integer"
-                },
-                "service_name": {
-                    "type": "string"
+        },
+        "/subs": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "APIKeyAuth": []
+                    }
+                ],
+                "description": "Get a page of subscriptions ordered by start date. Pass next_cursor of the response as cursor to get the next page.\nWith format=csv, ndjson or xlsx, or Accept: text/csv or application/x-ndjson, every matching subscription is streamed as a file and limit is ignored.",
+                "produces": [
+                    "application/json",
+                    "text/csv",
+                    "application/x-ndjson",
+                    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+                ],
+                "tags": [
+                    "subscriptions"
+                ],
+                "summary": "Get list of subscriptions",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID (optional), callers without the admin scope always get their own",
+                        "name": "user_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Service name (optional)",
+                        "name": "service_name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "07-2025",
+                        "description": "Only subscriptions active in the month (optional)",
+                        "name": "active_at",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Minimal price (optional)",
+                        "name": "price_min",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximal price (optional)",
+                        "name": "price_max",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "-start_date",
+                            "start_date"
+                        ],
+                        "type": "string",
+                        "default": "-start_date",
+                        "description": "Sort order (optional)",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "maximum": 500,
+                        "minimum": 1,
+                        "type": "integer",
+                        "default": 50,
+                        "description": "Page size (optional)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cursor of the page (optional)",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "List deleted subscriptions too, admin only (optional)",
+                        "name": "include_deleted",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "json",
+                            "csv",
+                            "ndjson",
+                            "xlsx"
+                        ],
+                        "type": "string",
+                        "description": "Response format, overrides the Accept header (optional)",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.SubscriptionPage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "include_deleted without the admin permission",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "Request cancelled",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "APIKeyAuth": []
+                    }
+                ],
+                "description": "Create a new subscription record. Callers without the admin scope always create it for themselves",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "subscriptions"
+                ],
+                "summary": "Create subscription",
+                "parameters": [
+                    {
+                        "description": "Subscription object",
+                        "name": "subscription",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.Subscription"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Who makes the change, recorded in the history (optional)",
+                        "name": "X-Actor",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Retries with the same key get the response of the first request (optional)",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/model.Subscription"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict, or a request with the same Idempotency-Key is in progress",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Idempotency-Key was used with a different request",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "Request cancelled",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/subs/aggregate": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "APIKeyAuth": []
+                    }
+                ],
+                "description": "Sum prices between dates, optional filters user_id \u0026 service_name.\nPrices are normalized to a month by their billing cycle, a 1200 per year plan costs 100.\nTotals are in minor units of the currency, every cost is converted at the rate of its month. If rates are missing, they are listed in a 422 response.\nBy default every subscription is counted once for each month it is active within the period (mode=prorated),\nmode=starts sums monthly costs of subscriptions started within the period.\nThe result can be downloaded as a table with format=csv, ndjson or xlsx.",
+                "produces": [
+                    "application/json",
+                    "text/csv",
+                    "application/x-ndjson",
+                    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+                ],
+                "tags": [
+                    "subscriptions"
+                ],
+                "summary": "Aggregate subscriptions cost",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "01-2025",
+                        "description": "Start month-year",
+                        "name": "from",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "example": "07-2025",
+                        "description": "End month-year",
+                        "name": "to",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "User ID (optional), callers without the admin scope always get their own",
+                        "name": "user_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Service name(optional)",
+                        "name": "service_name",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "prorated",
+                            "starts"
+                        ],
+                        "type": "string",
+                        "description": "Aggregation mode (optional)",
+                        "name": "mode",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "default": "RUB",
+                        "description": "Currency of the totals (optional)",
+                        "name": "currency",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Count deleted subscriptions too, admin only (optional)",
+                        "name": "include_deleted",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "enum": [
+                                "service_name",
+                                "user_id"
+                            ],
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Group by dimensions (optional), returns a list of {key, total, count}",
+                        "name": "group_by",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "json",
+                            "csv",
+                            "ndjson",
+                            "xlsx"
+                        ],
+                        "type": "string",
+                        "description": "Response format, overrides the Accept header (optional)",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "integer"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "include_deleted without the admin permission",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Missing currency rates",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "Request cancelled",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/subs/aggregate/monthly": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "APIKeyAuth": []
+                    }
+                ],
+                "description": "Spend and number of active subscriptions for every month between dates, optional filters user_id \u0026 service_name.\nTotals are in minor units of the currency, converted at the rate of each month.\nThe result can be downloaded as a table with format=csv, ndjson or xlsx.",
+                "produces": [
+                    "application/json",
+                    "text/csv",
+                    "application/x-ndjson",
+                    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+                ],
+                "tags": [
+                    "subscriptions"
+                ],
+                "summary": "Monthly subscriptions cost",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "01-2025",
+                        "description": "Start month-year",
+                        "name": "from",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "example": "07-2025",
+                        "description": "End month-year",
+                        "name": "to",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "User ID (optional), callers without the admin scope always get their own",
+                        "name": "user_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Service name(optional)",
+                        "name": "service_name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "default": "RUB",
+                        "description": "Currency of the totals (optional)",
+                        "name": "currency",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Count deleted subscriptions too, admin only (optional)",
+                        "name": "include_deleted",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "json",
+                            "csv",
+                            "ndjson",
+                            "xlsx"
+                        ],
+                        "type": "string",
+                        "description": "Response format, overrides the Accept header (optional)",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.MonthlyAggregate"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "include_deleted without the admin permission",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Missing currency rates",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "Request cancelled",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/subs/batch": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "APIKeyAuth": []
+                    }
+                ],
+                "description": "Create, update and delete subscriptions in one request. Every create is applied first, then the updates and deletes in the order they are listed. In atomic mode (default) either every operation is applied or none, in best_effort mode every operation that succeeds is applied. Responds with 200 when every operation succeeded and 207 with the per operation results otherwise",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "subscriptions"
+                ],
+                "summary": "Batch of subscription changes",
+                "parameters": [
+                    {
+                        "description": "Operations to apply",
+                        "name": "batch",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.BatchRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Who makes the change, recorded in the history (optional)",
+                        "name": "X-Actor",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Retries with the same key get the response of the first request (optional)",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/router.BatchResponse"
+                        }
+                    },
+                    "207": {
+                        "description": "Some operations failed",
+                        "schema": {
+                            "$ref": "#/definitions/router.BatchResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "A request with the same Idempotency-Key is in progress",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Idempotency-Key was used with a different request",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "Request cancelled",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/subs/import": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "APIKeyAuth": []
+                    }
+                ],
+                "description": "Create subscriptions from a CSV file with a header row (service_name, price, user_id, start_date and optionally end_date, currency, billing_period, billing_interval) or from JSON Lines with one subscription per line. Prices are in minor units. Dates use the MM-YYYY layout. Invalid lines are reported and skipped, the other lines are imported",
+                "consumes": [
+                    "text/csv",
+                    "application/x-ndjson"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "subscriptions"
+                ],
+                "summary": "Import subscriptions",
+                "parameters": [
+                    {
+                        "description": "CSV or JSON Lines file",
+                        "name": "file",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Only validate the file (optional)",
+                        "name": "dry_run",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Who makes the change, recorded in the history (optional)",
+                        "name": "X-Actor",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/router.ImportResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "Request cancelled",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/subs/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "APIKeyAuth": []
+                    }
+                ],
+                "description": "Get subscription by its id",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "subscriptions"
+                ],
+                "summary": "Get subscription",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Return the subscription even if it is deleted, admin only (optional)",
+                        "name": "include_deleted",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of a cached copy (optional)",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.Subscription"
+                        }
+                    },
+                    "304": {
+                        "description": "Not Modified",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "include_deleted without the admin permission",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "Request cancelled",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "APIKeyAuth": []
+                    }
+                ],
+                "description": "Delete subscription by its id. The subscription can be restored until deleted subscriptions are purged",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "subscriptions"
+                ],
+                "summary": "Delete subscription",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Who makes the change, recorded in the history (optional)",
+                        "name": "X-Actor",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Apply only if the subscription still has this ETag (optional)",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Modified concurrently",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "Request cancelled",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "APIKeyAuth": []
+                    }
+                ],
+                "description": "Update subscription by its id with JSON Merge Patch semantics: absent fields are kept, \"end_date\": null removes the end date. Responds with the updated subscription",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "subscriptions"
+                ],
+                "summary": "Update subscription",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "UpdateSubscription object",
+                        "name": "subscription",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.UpdateSubscription"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Who makes the change, recorded in the history (optional)",
+                        "name": "X-Actor",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Apply only if the subscription still has this ETag (optional)",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.Subscription"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Modified concurrently",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "Request cancelled",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/subs/{id}/history": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "APIKeyAuth": []
+                    }
+                ],
+                "description": "Get the audit trail of a subscription, oldest change first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "subscriptions"
+                ],
+                "summary": "Subscription history",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.SubscriptionEvent"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "Request cancelled",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/subs/{id}/restore": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "APIKeyAuth": []
+                    }
+                ],
+                "description": "Restore a deleted subscription by its id. Restoring a subscription that is not deleted is a conflict",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "subscriptions"
+                ],
+                "summary": "Restore subscription",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Who makes the change, recorded in the history (optional)",
+                        "name": "X-Actor",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.Subscription"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Subscription is not deleted",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "Request cancelled",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/router.Problem"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "model.APIKey": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "prefix": {
+                    "description": "Prefix is the start of the key, to tell keys apart.",
+                    "type": "string"
+                },
+                "revoked_at": {
+                    "type": "string"
+                },
+                "roles": {
+                    "type": "array",
+                    "items": {
+                        "type": "string",
+                        "enum": [
+                            "reader",
+                            "writer",
+                            "admin"
+                        ]
+                    }
+                }
+            }
+        },
+        "model.BatchAction": {
+            "type": "string",
+            "enum": [
+                "create",
+                "update",
+                "delete"
+            ],
+            "x-enum-varnames": [
+                "BatchCreate",
+                "BatchUpdate",
+                "BatchDelete"
+            ]
+        },
+        "model.BatchMode": {
+            "type": "string",
+            "enum": [
+                "atomic",
+                "best_effort"
+            ],
+            "x-enum-varnames": [
+                "BatchAtomic",
+                "BatchBestEffort"
+            ]
+        },
+        "model.BatchOperation": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "enum": [
+                        "create",
+                        "update",
+                        "delete"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.BatchAction"
+                        }
+                    ]
+                },
+                "id": {
+                    "description": "ID of the subscription to update or delete.",
+                    "type": "string"
+                },
+                "subscription": {
+                    "description": "Subscription to create.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.Subscription"
+                        }
+                    ]
+                },
+                "update": {
+                    "description": "Update to apply.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.UpdateSubscription"
+                        }
+                    ]
+                },
+                "version": {
+                    "description": "Version makes an update or delete conditional like If-Match does.",
+                    "type": "integer"
+                }
+            }
+        },
+        "model.BatchRequest": {
+            "type": "object",
+            "properties": {
+                "mode": {
+                    "default": "atomic",
+                    "enum": [
+                        "atomic",
+                        "best_effort"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.BatchMode"
+                        }
+                    ]
+                },
+                "operations": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.BatchOperation"
+                    }
+                }
+            }
+        },
+        "model.BillingPeriod": {
+            "type": "string",
+            "enum": [
+                "week",
+                "month",
+                "quarter",
+                "year",
+                "month"
+            ],
+            "x-enum-varnames": [
+                "BillingWeek",
+                "BillingMonth",
+                "BillingQuarter",
+                "BillingYear",
+                "DefaultBillingPeriod"
+            ]
+        },
+        "model.CurrencyRate": {
+            "type": "object",
+            "properties": {
+                "currency": {
+                    "type": "string",
+                    "example": "USD"
+                },
+                "month": {
+                    "type": "string",
+                    "example": "01-2025"
+                },
+                "rate": {
+                    "description": "Rate is the price of one unit of the currency in DefaultCurrency.",
+                    "type": "number",
+                    "example": 92.5
+                }
+            }
+        },
+        "model.EventAction": {
+            "type": "string",
+            "enum": [
+                "create",
+                "update",
+                "delete",
+                "restore"
+            ],
+            "x-enum-varnames": [
+                "EventCreate",
+                "EventUpdate",
+                "EventDelete",
+                "EventRestore"
+            ]
+        },
+        "model.MissingRate": {
+            "type": "object",
+            "properties": {
+                "currency": {
+                    "description": "Currency whose rate for the month is missing.",
+                    "type": "string"
+                },
+                "month": {
+                    "type": "string"
+                },
+                "subscriptions": {
+                    "description": "Subscriptions is the number of subscriptions that could not be\nconverted because of it.",
+                    "type": "integer"
+                }
+            }
+        },
+        "model.MonthlyAggregate": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "type": "integer"
+                },
+                "month": {
+                    "type": "string"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "model.Subscription": {
+            "type": "object",
+            "properties": {
+                "billing_interval": {
+                    "type": "integer",
+                    "default": 1
+                },
+                "billing_period": {
+                    "description": "Price is charged every BillingInterval BillingPeriods, every month by\ndefault.",
+                    "default": "month",
+                    "enum": [
+                        "week",
+                        "month",
+                        "quarter",
+                        "year"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.BillingPeriod"
+                        }
+                    ]
+                },
+                "currency": {
+                    "type": "string",
+                    "default": "RUB",
+                    "example": "RUB"
+                },
+                "deleted_at": {
+                    "type": "string"
+                },
+                "end_date": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "price": {
+                    "description": "Price is in minor units of Currency, kopecks for RUB and cents for USD.",
+                    "type": "integer"
+                },
+                "service_name": {
+                    "type": "string"
                 },
                 "start_date": {
                     "type": "string"
                 },
                 "user_id": {
                     "type": "string"
+                },
+                "version": {
+                    "description": "Version is incremented by every change and served as the ETag.",
+                    "type": "integer"
+                }
+            }
+        },
+        "model.SubscriptionEvent": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "$ref": "#/definitions/model.EventAction"
+                },
+                "actor": {
+                    "type": "string"
+                },
+                "after": {
+                    "type": "object"
+                },
+                "before": {
+                    "type": "object"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "diff": {
+                    "description": "Diff maps every changed field to its {\"from\", \"to\"} values.",
+                    "type": "object"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "request_id": {
+                    "type": "string"
+                },
+                "subscription_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.SubscriptionPage": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.Subscription"
+                    }
+                },
+                "next_cursor": {
+                    "type": "string"
                 }
             }
         },
         "model.UpdateSubscription": {
             "type": "object",
             "properties": {
+                "billing_interval": {
+                    "type": "integer"
+                },
+                "billing_period": {
+                    "type": "string",
+                    "enum": [
+                        "week",
+                        "month",
+                        "quarter",
+                        "year"
+                    ]
+                },
+                "currency": {
+                    "type": "string"
+                },
                 "end_date": {
                     "type": "string"
                 },
@@ -297,6 +1933,195 @@ const docTemplate = `{
                     "type": "string"
                 }
             }
+        },
+        "router.BatchItemResult": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "$ref": "#/definitions/model.BatchAction"
+                },
+                "error": {
+                    "$ref": "#/definitions/router.Problem"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "index": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "integer"
+                },
+                "subscription": {
+                    "$ref": "#/definitions/model.Subscription"
+                }
+            }
+        },
+        "router.BatchResponse": {
+            "type": "object",
+            "properties": {
+                "committed": {
+                    "description": "Committed tells whether the successful operations were applied.",
+                    "type": "boolean"
+                },
+                "results": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/router.BatchItemResult"
+                    }
+                }
+            }
+        },
+        "router.CreateAPIKeyRequest": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string",
+                    "example": "billing-cron"
+                },
+                "roles": {
+                    "type": "array",
+                    "items": {
+                        "type": "string",
+                        "enum": [
+                            "reader",
+                            "writer",
+                            "admin"
+                        ]
+                    }
+                }
+            }
+        },
+        "router.CreatedAPIKey": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "prefix": {
+                    "description": "Prefix is the start of the key, to tell keys apart.",
+                    "type": "string"
+                },
+                "revoked_at": {
+                    "type": "string"
+                },
+                "roles": {
+                    "type": "array",
+                    "items": {
+                        "type": "string",
+                        "enum": [
+                            "reader",
+                            "writer",
+                            "admin"
+                        ]
+                    }
+                }
+            }
+        },
+        "router.ImportLineError": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "$ref": "#/definitions/router.Problem"
+                },
+                "line": {
+                    "type": "integer"
+                }
+            }
+        },
+        "router.ImportResponse": {
+            "type": "object",
+            "properties": {
+                "dry_run": {
+                    "type": "boolean"
+                },
+                "errors": {
+                    "description": "Errors lists the rejected lines, at most 1000 of them.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/router.ImportLineError"
+                    }
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "imported": {
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "router.Problem": {
+            "type": "object",
+            "properties": {
+                "detail": {
+                    "type": "string"
+                },
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/service.FieldError"
+                    }
+                },
+                "instance": {
+                    "type": "string"
+                },
+                "missing_rates": {
+                    "description": "MissingRates lists the currency rates an aggregate lacks.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.MissingRate"
+                    }
+                },
+                "request_id": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "integer"
+                },
+                "title": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "service.FieldError": {
+            "type": "object",
+            "properties": {
+                "field": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                }
+            }
+        }
+    },
+    "securityDefinitions": {
+        "APIKeyAuth": {
+            "description": "API key of a service account, accepted when API_KEYS_ENABLED is set",
+            "type": "apiKey",
+            "name": "X-API-Key",
+            "in": "header"
+        },
+        "BearerAuth": {
+            "description": "JWT as \"Bearer \u003ctoken\u003e\", required when authentication is configured",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
         }
     }
 }`
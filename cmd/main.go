@@ -1,11 +1,21 @@
 package main
 
 import (
+	"context"
+	"time"
+
 	"github.com/DeneesK/sub-service/internal/app"
 	"github.com/DeneesK/sub-service/internal/config"
 	"github.com/DeneesK/sub-service/internal/db"
+	"github.com/DeneesK/sub-service/internal/metrics"
+	"github.com/DeneesK/sub-service/internal/repository"
+	"github.com/DeneesK/sub-service/internal/router"
+	"github.com/DeneesK/sub-service/internal/router/middlewares"
 	"github.com/DeneesK/sub-service/internal/service"
+	"github.com/DeneesK/sub-service/internal/tracing"
 	"github.com/DeneesK/sub-service/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
 // @title Subscription API
@@ -13,12 +23,30 @@ import (
 // @description API for managing subscriptions
 // @host localhost:8000
 // @BasePath /api/v1
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description JWT as "Bearer <token>", required when authentication is configured
+// @securityDefinitions.apikey APIKeyAuth
+// @in header
+// @name X-API-Key
+// @description API key of a service account, accepted when API_KEYS_ENABLED is set
 func main() {
 	conf := config.MustLoad()
 
 	log := logger.NewLogger(conf.LogLevel)
 
-	db, err := db.InitDBConnection(
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    conf.TracingExporter,
+		Endpoint:    conf.TracingOTLPEndpoint,
+		ServiceName: "sub-service",
+		SampleRatio: conf.TracingSampleRatio,
+	})
+	if err != nil {
+		log.Fatalf("Failed to init tracing: %v", err)
+	}
+
+	pg, err := db.InitDBConnection(
 		conf.MigrationPath,
 		conf.DBHost, conf.DBPort,
 		conf.DBUser, conf.DBPassword,
@@ -29,8 +57,75 @@ func main() {
 		log.Fatalf("Failed to init db: %v", err)
 	}
 	log.Info("DB initialized successfully")
-	subService := service.NewSubscriptionService(db, log)
+	latestMigration, err := db.LatestMigration(conf.MigrationPath)
+	if err != nil {
+		log.Fatalf("Failed to read migrations: %v", err)
+	}
+	subRepo := repository.NewPostgresSubscriptionRepository(pg)
+	subService := service.NewSubscriptionService(subRepo, log)
+
+	if conf.CurrencyRatesFile != "" {
+		n, err := subService.LoadRatesFile(context.Background(), conf.CurrencyRatesFile)
+		if err != nil {
+			log.Fatalf("Failed to load currency rates: %v", err)
+		}
+		log.Infof("Loaded %d currency rates from %s", n, conf.CurrencyRatesFile)
+	}
 
-	a := app.NewApp(conf.ServerAddr, conf.TimeOut, log, subService)
+	purge := app.PurgeConfig{
+		Interval:  conf.PurgeInterval,
+		Retention: conf.DeletedRetention,
+	}
+	idempotency := router.IdempotencyConfig{
+		Store: repository.NewPostgresIdempotencyStore(pg),
+		TTL:   conf.IdempotencyTTL,
+	}
+	verifier, err := middlewares.NewJWTVerifier(middlewares.JWTConfig{
+		HMACSecret: conf.JWTSecret,
+		JWKSFile:   conf.JWTJWKSFile,
+		Issuer:     conf.JWTIssuer,
+		Audience:   conf.JWTAudience,
+	})
+	if err != nil {
+		log.Fatalf("Failed to init authentication: %v", err)
+	}
+	auth := middlewares.AuthConfig{Verifier: verifier, AdminScope: conf.JWTAdminScope}
+
+	keyService := service.NewAPIKeyService(repository.NewPostgresAPIKeyRepository(pg), log)
+	if conf.APIKeysEnabled {
+		auth.APIKeys = keyService
+		if conf.AdminAPIKey != "" {
+			if err := keyService.BootstrapAPIKey(context.Background(), conf.AdminAPIKey); err != nil {
+				log.Fatalf("Failed to store the admin API key: %v", err)
+			}
+		}
+	}
+	switch {
+	case conf.AuthDisabled && (auth.Verifier != nil || auth.APIKeys != nil):
+		log.Fatal("AUTH_DISABLED cannot be combined with JWT_HMAC_SECRET, JWT_JWKS_FILE or API_KEYS_ENABLED")
+	case conf.AuthDisabled:
+		auth.Disabled = true
+		log.Warn("Authentication is disabled by AUTH_DISABLED, every request is served as admin")
+	case auth.Verifier == nil && auth.APIKeys == nil:
+		log.Fatal("No authentication is configured, set JWT_HMAC_SECRET, JWT_JWKS_FILE or API_KEYS_ENABLED, or AUTH_DISABLED=true for local runs")
+	}
+	metricsRegistry := prometheus.NewRegistry()
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(pg.DB, conf.DBName),
+		metrics.NewSubscriptionCollector(subService, conf.SubscriptionStatsTTL, log),
+	)
+	health := app.HealthConfig{
+		Check:      db.NewReadinessCheck(pg, latestMigration),
+		DrainDelay: conf.ShutdownDrainDelay,
+	}
+	a := app.NewApp(conf.ServerAddr, conf.TimeOut, purge, idempotency, auth, metricsRegistry, health, log, subService, keyService)
 	a.Run()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		log.Errorf("Failed to flush traces: %v", err)
+	}
 }
@@ -1,133 +1,76 @@
 package main
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
-	"sync"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/DeneesK/sub-service/internal/metrics"
 	"github.com/DeneesK/sub-service/internal/model"
+	"github.com/DeneesK/sub-service/internal/repository"
 	"github.com/DeneesK/sub-service/internal/router"
+	"github.com/DeneesK/sub-service/internal/router/middlewares"
+	"github.com/DeneesK/sub-service/internal/service"
+	"github.com/DeneesK/sub-service/internal/tracing"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
-type MockSubscriptionService struct {
-	data map[string]*model.Subscription
-	mu   sync.RWMutex
-}
-
-func NewMockSubscriptionService() *MockSubscriptionService {
-	return &MockSubscriptionService{
-		data: make(map[string]*model.Subscription),
-	}
-}
-
-func (m *MockSubscriptionService) Create(sub *model.Subscription) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	id := uuid.New().String()
-	sub.ID = id
-	m.data[id] = sub
-	return nil
-}
-
-func (m *MockSubscriptionService) Get(id string) (*model.Subscription, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	sub, ok := m.data[id]
-	if !ok {
-		return nil, errors.New("not found")
-	}
-	return sub, nil
-}
-
-func (m *MockSubscriptionService) List(userID string) ([]model.Subscription, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	var res []model.Subscription
-	for _, sub := range m.data {
-		if userID == "" || sub.UserID == userID {
-			res = append(res, *sub)
-		}
-	}
-	return res, nil
-}
+var subSvc *service.SubscriptionService
 
-func (m *MockSubscriptionService) Update(id string, upd *model.UpdateSubscription) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	sub, ok := m.data[id]
-	if !ok {
-		return errors.New("not found")
-	}
-
-	if upd.ServiceName != nil {
-		sub.ServiceName = *upd.ServiceName
-	}
-	if upd.Price != nil {
-		sub.Price = *upd.Price
-	}
-	if upd.UserID != nil {
-		sub.UserID = *upd.UserID
-	}
-	if upd.StartDate != nil {
-		sub.StartDate = *upd.StartDate
-	}
-	if upd.EndDate != nil {
-		sub.EndDate = upd.EndDate
-	}
-
-	return nil
+func testUserID(n int) string {
+	return fmt.Sprintf("00000000-0000-0000-0000-%012d", n)
 }
 
-func (m *MockSubscriptionService) Delete(id string) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	if _, ok := m.data[id]; !ok {
-		return errors.New("not found")
-	}
-	delete(m.data, id)
-	return nil
+func setupTestRouter() *chi.Mux {
+	return setupTestRouterWithAuth(middlewares.AuthConfig{Disabled: true}, newTestAPIKeyService())
 }
 
-func (m *MockSubscriptionService) Aggregate(from, to time.Time, userID, service string) (int, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	sum := 0
-	for _, sub := range m.data {
-		if sub.StartDate.Time.Before(from) || sub.StartDate.Time.After(to) {
-			continue
-		}
-		if userID != "" && sub.UserID != userID {
-			continue
-		}
-		if service != "" && sub.ServiceName != service {
-			continue
-		}
-		sum += sub.Price
-	}
-	return sum, nil
+func newTestAPIKeyService() *service.APIKeyService {
+	return service.NewAPIKeyService(repository.NewMemoryAPIKeyRepository(), zap.NewExample().Sugar())
 }
 
-var mockSvc *MockSubscriptionService
-
-func setupTestRouter() *chi.Mux {
-	mockSvc = NewMockSubscriptionService()
+func setupTestRouterWithAuth(auth middlewares.AuthConfig, keys router.APIKeyService) *chi.Mux {
 	logger := zap.NewExample().Sugar()
-	r := router.NewRouter(time.Duration(30)*time.Second, mockSvc, logger)
+	subSvc = service.NewSubscriptionService(repository.NewMemorySubscriptionRepository(), logger)
+	idempotency := router.IdempotencyConfig{
+		Store: repository.NewMemoryIdempotencyStore(),
+		TTL:   24 * time.Hour,
+	}
+	metricsRegistry := prometheus.NewRegistry()
+	metricsRegistry.MustRegister(metrics.NewSubscriptionCollector(subSvc, time.Minute, logger))
+	r := router.NewRouter(time.Duration(30)*time.Second, subSvc, keys, idempotency, auth, metricsRegistry, nil, logger)
 	return r
 }
 
@@ -137,7 +80,7 @@ func TestCreateSubscription(t *testing.T) {
 	payload := map[string]interface{}{
 		"service_name": "Netflix",
 		"price":        500,
-		"user_id":      "user-1",
+		"user_id":      testUserID(1),
 		"start_date":   "07-2025",
 	}
 	body, _ := json.Marshal(payload)
@@ -155,17 +98,17 @@ func TestCreateSubscription(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "Netflix", resp.ServiceName)
 	assert.Equal(t, 500, resp.Price)
-	assert.Equal(t, "user-1", resp.UserID)
+	assert.Equal(t, testUserID(1), resp.UserID)
 	assert.NotEmpty(t, resp.ID)
 }
 
 func TestListSubscriptions(t *testing.T) {
 	r := setupTestRouter()
 
-	mockSvc.Create(&model.Subscription{
+	subSvc.Create(context.Background(), &model.Subscription{
 		ServiceName: "Spotify",
 		Price:       300,
-		UserID:      "user-2",
+		UserID:      testUserID(2),
 		StartDate:   model.MonthYear{Time: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)},
 	})
 
@@ -176,10 +119,11 @@ func TestListSubscriptions(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, w.Code)
 
-	var subs []model.Subscription
-	err := json.Unmarshal(w.Body.Bytes(), &subs)
+	var page model.SubscriptionPage
+	err := json.Unmarshal(w.Body.Bytes(), &page)
 	assert.NoError(t, err)
-	assert.True(t, len(subs) >= 1)
+	assert.True(t, len(page.Items) >= 1)
+	assert.Empty(t, page.NextCursor)
 }
 
 func TestUpdateSubscription(t *testing.T) {
@@ -188,10 +132,10 @@ func TestUpdateSubscription(t *testing.T) {
 	sub := &model.Subscription{
 		ServiceName: "Apple Music",
 		Price:       400,
-		UserID:      "user-3",
+		UserID:      testUserID(3),
 		StartDate:   model.MonthYear{Time: time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)},
 	}
-	mockSvc.Create(sub)
+	subSvc.Create(context.Background(), sub)
 
 	updatePayload := map[string]interface{}{
 		"price": 450,
@@ -211,6 +155,52 @@ func TestUpdateSubscription(t *testing.T) {
 	err := json.Unmarshal(w.Body.Bytes(), &updated)
 	assert.NoError(t, err)
 	assert.Equal(t, 450, updated.Price)
+	assert.Equal(t, sub.ID, updated.ID)
+	assert.Equal(t, "Apple Music", updated.ServiceName)
+	assert.Equal(t, sub.UserID, updated.UserID)
+	assert.Equal(t, "05-2025", updated.StartDate.Format("01-2006"))
+	assert.EqualValues(t, 2, updated.Version)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+}
+
+func TestUpdateSubscriptionClearsEndDate(t *testing.T) {
+	r := setupTestRouter()
+
+	endDate := model.MonthYear{Time: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)}
+	sub := &model.Subscription{
+		ServiceName: "Apple Music",
+		Price:       400,
+		UserID:      testUserID(18),
+		StartDate:   model.MonthYear{Time: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		EndDate:     &endDate,
+	}
+	err := subSvc.Create(context.Background(), sub)
+	assert.NoError(t, err)
+
+	patch := func(body string) model.Subscription {
+		req := httptest.NewRequest(http.MethodPatch, "/api/v1/subs/"+sub.ID, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var updated model.Subscription
+		json.Unmarshal(w.Body.Bytes(), &updated)
+		return updated
+	}
+
+	// An absent end_date is kept.
+	updated := patch(`{"price": 450}`)
+	if assert.NotNil(t, updated.EndDate) {
+		assert.Equal(t, "06-2025", updated.EndDate.Format("01-2006"))
+	}
+
+	updated = patch(`{"end_date": null}`)
+	assert.Nil(t, updated.EndDate)
+	assert.Equal(t, 450, updated.Price)
+
+	stored, err := subSvc.Get(context.Background(), sub.ID, false)
+	assert.NoError(t, err)
+	assert.Nil(t, stored.EndDate)
 }
 
 func TestDeleteSubscription(t *testing.T) {
@@ -219,10 +209,10 @@ func TestDeleteSubscription(t *testing.T) {
 	sub := &model.Subscription{
 		ServiceName: "Deezer",
 		Price:       200,
-		UserID:      "user-4",
+		UserID:      testUserID(4),
 		StartDate:   model.MonthYear{Time: time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)},
 	}
-	mockSvc.Create(sub)
+	subSvc.Create(context.Background(), sub)
 
 	url := "/api/v1/subs/" + sub.ID
 	req := httptest.NewRequest(http.MethodDelete, url, nil)
@@ -232,27 +222,27 @@ func TestDeleteSubscription(t *testing.T) {
 
 	assert.Equal(t, http.StatusNoContent, w.Code)
 
-	_, err := mockSvc.Get(sub.ID)
-	assert.Error(t, err)
+	_, err := subSvc.Get(context.Background(), sub.ID, false)
+	assert.ErrorIs(t, err, service.ErrNotFound)
 }
 
 func TestAggregateSubscription(t *testing.T) {
 	r := setupTestRouter()
 
-	mockSvc.Create(&model.Subscription{
+	subSvc.Create(context.Background(), &model.Subscription{
 		ServiceName: "Netflix",
 		Price:       100,
-		UserID:      "user-5",
+		UserID:      testUserID(5),
 		StartDate:   model.MonthYear{Time: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
 	})
-	mockSvc.Create(&model.Subscription{
+	subSvc.Create(context.Background(), &model.Subscription{
 		ServiceName: "Netflix",
 		Price:       200,
-		UserID:      "user-5",
+		UserID:      testUserID(5),
 		StartDate:   model.MonthYear{Time: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
 	})
 
-	url := "/api/v1/subs/aggregate?from=01-2020&to=02-2025&user_id=user-5&service_name=Netflix"
+	url := "/api/v1/subs/aggregate?from=01-2020&to=02-2025&user_id=" + testUserID(5) + "&service_name=Netflix&mode=starts"
 	req := httptest.NewRequest(http.MethodGet, url, nil)
 	w := httptest.NewRecorder()
 
@@ -265,3 +255,1767 @@ func TestAggregateSubscription(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 300, resp["total"])
 }
+
+func TestAggregateSubscriptionProrated(t *testing.T) {
+	r := setupTestRouter()
+
+	endDate := model.MonthYear{Time: time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)}
+	subSvc.Create(context.Background(), &model.Subscription{
+		ServiceName: "Netflix",
+		Price:       100,
+		UserID:      testUserID(6),
+		StartDate:   model.MonthYear{Time: time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)},
+	})
+	subSvc.Create(context.Background(), &model.Subscription{
+		ServiceName: "Spotify",
+		Price:       200,
+		UserID:      testUserID(6),
+		StartDate:   model.MonthYear{Time: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+		EndDate:     &endDate,
+	})
+	subSvc.Create(context.Background(), &model.Subscription{
+		ServiceName: "Deezer",
+		Price:       300,
+		UserID:      testUserID(6),
+		StartDate:   model.MonthYear{Time: time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)},
+	})
+
+	url := "/api/v1/subs/aggregate?from=01-2025&to=06-2025&user_id=" + testUserID(6)
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp map[string]int
+	err := json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.NoError(t, err)
+	assert.Equal(t, 100*6+200*3, resp["total"])
+}
+
+func TestAggregateSubscriptionInvalidMode(t *testing.T) {
+	r := setupTestRouter()
+
+	url := "/api/v1/subs/aggregate?from=01-2025&to=06-2025&mode=weekly"
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAggregateSubscriptionMonthly(t *testing.T) {
+	r := setupTestRouter()
+
+	endDate := model.MonthYear{Time: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)}
+	subSvc.Create(context.Background(), &model.Subscription{
+		ServiceName: "Netflix",
+		Price:       100,
+		UserID:      testUserID(7),
+		StartDate:   model.MonthYear{Time: time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)},
+	})
+	subSvc.Create(context.Background(), &model.Subscription{
+		ServiceName: "Spotify",
+		Price:       200,
+		UserID:      testUserID(7),
+		StartDate:   model.MonthYear{Time: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+		EndDate:     &endDate,
+	})
+
+	url := "/api/v1/subs/aggregate/monthly?from=01-2025&to=03-2025&user_id=" + testUserID(7)
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp []model.MonthlyAggregate
+	err := json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.NoError(t, err)
+	assert.Len(t, resp, 3)
+	assert.Equal(t, "01-2025", resp[0].Month.Format("01-2006"))
+	assert.Equal(t, 100, resp[0].Total)
+	assert.Equal(t, 1, resp[0].Count)
+	assert.Equal(t, 300, resp[1].Total)
+	assert.Equal(t, 2, resp[1].Count)
+	assert.Equal(t, 100, resp[2].Total)
+	assert.Equal(t, 1, resp[2].Count)
+}
+
+func TestAggregateSubscriptionGroupBy(t *testing.T) {
+	r := setupTestRouter()
+
+	for _, sub := range []*model.Subscription{
+		{ServiceName: "Netflix", Price: 100, UserID: testUserID(8)},
+		{ServiceName: "Netflix", Price: 150, UserID: testUserID(9)},
+		{ServiceName: "Spotify", Price: 200, UserID: testUserID(8)},
+	} {
+		sub.StartDate = model.MonthYear{Time: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+		subSvc.Create(context.Background(), sub)
+	}
+
+	url := "/api/v1/subs/aggregate?from=01-2025&to=02-2025&group_by=service_name"
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp []model.AggregateGroup
+	err := json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.NoError(t, err)
+	assert.Equal(t, []model.AggregateGroup{
+		{Key: map[model.AggregateDimension]string{"service_name": "Netflix"}, Total: 500, Count: 2},
+		{Key: map[model.AggregateDimension]string{"service_name": "Spotify"}, Total: 400, Count: 1},
+	}, resp)
+
+	url = "/api/v1/subs/aggregate?from=01-2025&to=01-2025&group_by=service_name,user_id"
+	req = httptest.NewRequest(http.MethodGet, url, nil)
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	resp = nil
+	err = json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.NoError(t, err)
+	assert.Len(t, resp, 3)
+	assert.Equal(t, map[model.AggregateDimension]string{"service_name": "Netflix", "user_id": testUserID(8)}, resp[0].Key)
+	assert.Equal(t, 100, resp[0].Total)
+
+	url = "/api/v1/subs/aggregate?from=01-2024&to=12-2024&group_by=service_name"
+	req = httptest.NewRequest(http.MethodGet, url, nil)
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[]`, w.Body.String())
+}
+
+func TestAggregateSubscriptionInvalidGroupBy(t *testing.T) {
+	r := setupTestRouter()
+
+	url := "/api/v1/subs/aggregate?from=01-2025&to=06-2025&group_by=price"
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCancelledRequest(t *testing.T) {
+	r := setupTestRouter()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/subs", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestTimedOutRequest(t *testing.T) {
+	r := setupTestRouter()
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	url := "/api/v1/subs/aggregate?from=01-2025&to=06-2025"
+	req := httptest.NewRequest(http.MethodGet, url, nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+}
+
+func TestSubscriptionErrors(t *testing.T) {
+	r := setupTestRouter()
+
+	missing := "/api/v1/subs/" + uuid.NewString()
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		status int
+	}{
+		{"get malformed id", http.MethodGet, "/api/v1/subs/not-a-uuid", "", http.StatusBadRequest},
+		{"get missing", http.MethodGet, missing, "", http.StatusNotFound},
+		{"update malformed id", http.MethodPatch, "/api/v1/subs/not-a-uuid", `{"price": 1}`, http.StatusBadRequest},
+		{"update missing", http.MethodPatch, missing, `{"price": 1}`, http.StatusNotFound},
+		{"empty update missing", http.MethodPatch, missing, `{}`, http.StatusNotFound},
+		{"delete malformed id", http.MethodDelete, "/api/v1/subs/not-a-uuid", "", http.StatusBadRequest},
+		{"delete missing", http.MethodDelete, missing, "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+
+			var problem router.Problem
+			err := json.Unmarshal(w.Body.Bytes(), &problem)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.status, problem.Status)
+			assert.Equal(t, http.StatusText(tt.status), problem.Title)
+			assert.NotEmpty(t, problem.Type)
+			assert.NotEmpty(t, problem.Detail)
+			assert.NotEmpty(t, problem.RequestID)
+			assert.Equal(t, req.URL.Path, problem.Instance)
+		})
+	}
+}
+
+func TestAggregateValidationProblem(t *testing.T) {
+	r := setupTestRouter()
+
+	url := "/api/v1/subs/aggregate?from=2025-01&to=06-2025&mode=weekly&group_by=price"
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	assert.Equal(t, []string{"from", "mode", "group_by"}, problemFields(t, w))
+}
+
+func TestMalformedBodyProblem(t *testing.T) {
+	r := setupTestRouter()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/subs", bytes.NewBufferString("{"))
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var problem router.Problem
+	err := json.Unmarshal(w.Body.Bytes(), &problem)
+	assert.NoError(t, err)
+	assert.Equal(t, "/problems/malformed-request", problem.Type)
+}
+
+func problemFields(t *testing.T, w *httptest.ResponseRecorder) []string {
+	t.Helper()
+
+	var problem router.Problem
+	err := json.Unmarshal(w.Body.Bytes(), &problem)
+	assert.NoError(t, err)
+	assert.Equal(t, "/problems/validation-error", problem.Type)
+
+	var fields []string
+	for _, e := range problem.Errors {
+		fields = append(fields, e.Field)
+	}
+	return fields
+}
+
+func TestCreateSubscriptionValidation(t *testing.T) {
+	r := setupTestRouter()
+
+	payload := map[string]interface{}{
+		"service_name": " ",
+		"price":        -1,
+		"user_id":      "user-1",
+		"start_date":   "07-2025",
+		"end_date":     "06-2025",
+	}
+	body, _ := json.Marshal(payload)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/subs", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []string{"service_name", "price", "user_id", "end_date"}, problemFields(t, w))
+}
+
+func TestUpdateSubscriptionValidatesMergedResult(t *testing.T) {
+	r := setupTestRouter()
+
+	endDate := model.MonthYear{Time: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)}
+	sub := &model.Subscription{
+		ServiceName: "Apple Music",
+		Price:       400,
+		UserID:      testUserID(10),
+		StartDate:   model.MonthYear{Time: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		EndDate:     &endDate,
+	}
+	err := subSvc.Create(context.Background(), sub)
+	assert.NoError(t, err)
+
+	body := bytes.NewBufferString(`{"start_date": "08-2025"}`)
+	req := httptest.NewRequest(http.MethodPatch, "/api/v1/subs/"+sub.ID, body)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []string{"end_date"}, problemFields(t, w))
+
+	stored, err := subSvc.Get(context.Background(), sub.ID, false)
+	assert.NoError(t, err)
+	assert.Equal(t, sub.StartDate, stored.StartDate)
+}
+
+func TestListSubscriptionsPagination(t *testing.T) {
+	r := setupTestRouter()
+
+	for month := 1; month <= 5; month++ {
+		err := subSvc.Create(context.Background(), &model.Subscription{
+			ServiceName: "Netflix",
+			Price:       100 * month,
+			UserID:      testUserID(11),
+			StartDate:   model.MonthYear{Time: time.Date(2025, time.Month(month), 1, 0, 0, 0, 0, time.UTC)},
+		})
+		assert.NoError(t, err)
+	}
+
+	list := func(query string) model.SubscriptionPage {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/subs?"+query, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var page model.SubscriptionPage
+		err := json.Unmarshal(w.Body.Bytes(), &page)
+		assert.NoError(t, err)
+		return page
+	}
+	prices := func(page model.SubscriptionPage) []int {
+		var res []int
+		for _, sub := range page.Items {
+			res = append(res, sub.Price)
+		}
+		return res
+	}
+
+	page := list("limit=2")
+	assert.Equal(t, []int{500, 400}, prices(page))
+	assert.NotEmpty(t, page.NextCursor)
+
+	page = list("limit=2&cursor=" + page.NextCursor)
+	assert.Equal(t, []int{300, 200}, prices(page))
+
+	page = list("limit=2&cursor=" + page.NextCursor)
+	assert.Equal(t, []int{100}, prices(page))
+	assert.Empty(t, page.NextCursor)
+
+	page = list("sort=start_date&limit=3&price_min=200&price_max=400")
+	assert.Equal(t, []int{200, 300, 400}, prices(page))
+	assert.Empty(t, page.NextCursor)
+
+	page = list("active_at=03-2025")
+	assert.Equal(t, []int{300, 200, 100}, prices(page))
+
+	page = list("service_name=Spotify")
+	assert.NotNil(t, page.Items)
+	assert.Empty(t, page.Items)
+}
+
+func TestListSubscriptionsValidation(t *testing.T) {
+	r := setupTestRouter()
+
+	for month := 1; month <= 2; month++ {
+		err := subSvc.Create(context.Background(), &model.Subscription{
+			ServiceName: "Netflix",
+			Price:       100,
+			UserID:      testUserID(12),
+			StartDate:   model.MonthYear{Time: time.Date(2025, time.Month(month), 1, 0, 0, 0, 0, time.UTC)},
+		})
+		assert.NoError(t, err)
+	}
+	page, err := subSvc.List(context.Background(), model.ListFilter{Limit: 1})
+	assert.NoError(t, err)
+	encodedDescCursor := page.NextCursor
+	var cursor model.ListCursor
+	data, err := base64.RawURLEncoding.DecodeString(encodedDescCursor)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(data, &cursor))
+	cursor.ID = "1 OR 1=1"
+	data, _ = json.Marshal(cursor)
+	tamperedCursor := base64.RawURLEncoding.EncodeToString(data)
+
+	tests := []struct {
+		query  string
+		fields []string
+	}{
+		{"limit=0", []string{"limit"}},
+		{"limit=1000", []string{"limit"}},
+		{"limit=ten&price_min=x", []string{"price_min", "limit"}},
+		{"active_at=2025-03", []string{"active_at"}},
+		{"sort=price", []string{"sort"}},
+		{"cursor=not-a-cursor", []string{"cursor"}},
+		{"sort=start_date&cursor=" + encodedDescCursor, []string{"cursor"}},
+		{"cursor=" + tamperedCursor, []string{"cursor"}},
+		{"price_min=10&price_max=5", []string{"price_max"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/subs?"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Equal(t, tt.fields, problemFields(t, w))
+		})
+	}
+}
+
+func TestSoftDeleteAndRestoreSubscription(t *testing.T) {
+	r := setupTestRouter()
+
+	sub := &model.Subscription{
+		ServiceName: "Deezer",
+		Price:       200,
+		UserID:      testUserID(13),
+		StartDate:   model.MonthYear{Time: time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	err := subSvc.Create(context.Background(), sub)
+	assert.NoError(t, err)
+
+	do := func(method, url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	aggregate := func(query string) int {
+		w := do(http.MethodGet, "/api/v1/subs/aggregate?from=04-2025&to=04-2025&user_id="+testUserID(13)+query)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var resp map[string]int
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp["total"]
+	}
+	list := func(query string) int {
+		w := do(http.MethodGet, "/api/v1/subs?user_id="+testUserID(13)+query)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var page model.SubscriptionPage
+		json.Unmarshal(w.Body.Bytes(), &page)
+		return len(page.Items)
+	}
+
+	w := do(http.MethodDelete, "/api/v1/subs/"+sub.ID)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/v1/subs/"+sub.ID).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/api/v1/subs/"+sub.ID).Code)
+	assert.Equal(t, 0, list(""))
+	assert.Equal(t, 1, list("&include_deleted=true"))
+	assert.Equal(t, 0, aggregate(""))
+	assert.Equal(t, 200, aggregate("&include_deleted=true"))
+
+	w = do(http.MethodGet, "/api/v1/subs/"+sub.ID+"?include_deleted=true")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var deleted model.Subscription
+	err = json.Unmarshal(w.Body.Bytes(), &deleted)
+	assert.NoError(t, err)
+	assert.NotNil(t, deleted.DeletedAt)
+
+	w = do(http.MethodPost, "/api/v1/subs/"+sub.ID+"/restore")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var restored model.Subscription
+	err = json.Unmarshal(w.Body.Bytes(), &restored)
+	assert.NoError(t, err)
+	assert.Nil(t, restored.DeletedAt)
+
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/v1/subs/"+sub.ID).Code)
+	assert.Equal(t, 1, list(""))
+	assert.Equal(t, 200, aggregate(""))
+}
+
+func TestRestoreLiveSubscription(t *testing.T) {
+	r := setupTestRouter()
+
+	sub := &model.Subscription{
+		ServiceName: "Deezer",
+		Price:       200,
+		UserID:      testUserID(14),
+		StartDate:   model.MonthYear{Time: time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	assert.NoError(t, subSvc.Create(context.Background(), sub))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/subs/"+sub.ID+"/restore", nil))
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// The subscription keeps its version and no restore is recorded.
+	current, err := subSvc.Get(context.Background(), sub.ID, false)
+	assert.NoError(t, err)
+	assert.Equal(t, sub.Version, current.Version)
+	events, err := subSvc.History(context.Background(), sub.ID)
+	assert.NoError(t, err)
+	for _, e := range events {
+		assert.NotEqual(t, model.EventRestore, e.Action)
+	}
+}
+
+func TestPurgeDeletedSubscriptions(t *testing.T) {
+	setupTestRouter()
+	ctx := context.Background()
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		sub := &model.Subscription{
+			ServiceName: "Deezer",
+			Price:       200,
+			UserID:      testUserID(14),
+			StartDate:   model.MonthYear{Time: time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)},
+		}
+		err := subSvc.Create(ctx, sub)
+		assert.NoError(t, err)
+		ids = append(ids, sub.ID)
+	}
+	err := subSvc.Delete(ctx, ids[0], 0)
+	assert.NoError(t, err)
+
+	n, err := subSvc.Purge(ctx, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+
+	n, err = subSvc.Purge(ctx, -time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	_, err = subSvc.Get(ctx, ids[0], true)
+	assert.ErrorIs(t, err, service.ErrNotFound)
+	_, err = subSvc.Get(ctx, ids[1], false)
+	assert.NoError(t, err)
+}
+
+func TestSubscriptionHistory(t *testing.T) {
+	r := setupTestRouter()
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("X-Actor", "admin@example.com")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/api/v1/subs",
+		`{"service_name": "Netflix", "price": 400, "user_id": "`+testUserID(15)+`", "start_date": "01-2025"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var sub model.Subscription
+	json.Unmarshal(w.Body.Bytes(), &sub)
+
+	assert.Equal(t, http.StatusOK, do(http.MethodPatch, "/api/v1/subs/"+sub.ID, `{"price": 450}`).Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/api/v1/subs/"+sub.ID, "").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/api/v1/subs/"+sub.ID+"/restore", "").Code)
+
+	w = do(http.MethodGet, "/api/v1/subs/"+sub.ID+"/history", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var events []model.SubscriptionEvent
+	err := json.Unmarshal(w.Body.Bytes(), &events)
+	assert.NoError(t, err)
+	if !assert.Len(t, events, 4) {
+		return
+	}
+
+	var actions []model.EventAction
+	for _, e := range events {
+		actions = append(actions, e.Action)
+		assert.Equal(t, sub.ID, e.SubscriptionID)
+		assert.Equal(t, "admin@example.com", e.Actor)
+		assert.NotEmpty(t, e.RequestID)
+	}
+	assert.Equal(t, []model.EventAction{
+		model.EventCreate, model.EventUpdate, model.EventDelete, model.EventRestore,
+	}, actions)
+
+	assert.Nil(t, events[0].Before)
+	assert.NotNil(t, events[0].After)
+	assert.JSONEq(t, `{"price": {"from": 400, "to": 450}}`, string(events[1].Diff))
+	assert.Contains(t, string(events[2].Diff), `"deleted_at"`)
+
+	w = do(http.MethodGet, "/api/v1/subs/"+uuid.NewString()+"/history", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestFailedUpdateIsNotRecorded(t *testing.T) {
+	setupTestRouter()
+	ctx := context.Background()
+
+	sub := &model.Subscription{
+		ServiceName: "Netflix",
+		Price:       400,
+		UserID:      testUserID(16),
+		StartDate:   model.MonthYear{Time: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	err := subSvc.Create(ctx, sub)
+	assert.NoError(t, err)
+
+	price := -1
+	_, err = subSvc.Update(ctx, sub.ID, 0, &model.UpdateSubscription{Price: model.Some(price)})
+	assert.ErrorIs(t, err, service.ErrValidation)
+
+	events, err := subSvc.History(ctx, sub.ID)
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, "anonymous", events[0].Actor)
+}
+
+func TestSubscriptionETag(t *testing.T) {
+	r := setupTestRouter()
+
+	do := func(method, url, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/api/v1/subs",
+		`{"service_name": "Netflix", "price": 400, "user_id": "`+testUserID(17)+`", "start_date": "01-2025"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+	var sub model.Subscription
+	json.Unmarshal(w.Body.Bytes(), &sub)
+	url := "/api/v1/subs/" + sub.ID
+
+	w = do(http.MethodGet, url, "", "If-None-Match", `"1"`)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+
+	w = do(http.MethodPatch, url, `{"price": 450}`, "If-Match", `"1"`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The second writer based its change on the same version and loses.
+	w = do(http.MethodPatch, url, `{"price": 500}`, "If-Match", `"1"`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	var p router.Problem
+	json.Unmarshal(w.Body.Bytes(), &p)
+	assert.Equal(t, "/problems/precondition-failed", p.Type)
+
+	w = do(http.MethodGet, url, "", "If-None-Match", `W/"1"`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+	json.Unmarshal(w.Body.Bytes(), &sub)
+	assert.Equal(t, 450, sub.Price)
+	assert.EqualValues(t, 2, sub.Version)
+
+	assert.Equal(t, http.StatusPreconditionFailed, do(http.MethodDelete, url, "", "If-Match", `"1"`).Code)
+	assert.Equal(t, http.StatusPreconditionFailed, do(http.MethodDelete, url, "", "If-Match", `W/"2"`).Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, url, "", "If-Match", `"2"`).Code)
+
+	w = do(http.MethodPost, url+"/restore", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"4"`, w.Header().Get("ETag"))
+
+	// Without If-Match the change is unconditional.
+	assert.Equal(t, http.StatusOK, do(http.MethodPatch, url, `{"price": 500}`).Code)
+}
+
+func TestUpdateSubscriptionNullableFields(t *testing.T) {
+	r := setupTestRouter()
+
+	endDate := model.MonthYear{Time: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)}
+	sub := &model.Subscription{
+		ServiceName: "Kinopoisk",
+		Price:       300,
+		UserID:      testUserID(19),
+		StartDate:   model.MonthYear{Time: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		EndDate:     &endDate,
+	}
+	err := subSvc.Create(context.Background(), sub)
+	assert.NoError(t, err)
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/api/v1/subs/"+sub.ID, bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// An empty date removes the end date like null does.
+	w := patch(`{"end_date": ""}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var updated model.Subscription
+	json.Unmarshal(w.Body.Bytes(), &updated)
+	assert.Nil(t, updated.EndDate)
+
+	w = patch(`{"end_date": "09-2025"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &updated)
+	if assert.NotNil(t, updated.EndDate) {
+		assert.Equal(t, "09-2025", updated.EndDate.Format("01-2006"))
+	}
+
+	w = patch(`{"service_name": null, "price": null, "end_date": null}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []string{"service_name", "price"}, problemFields(t, w))
+
+	stored, err := subSvc.Get(context.Background(), sub.ID, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "Kinopoisk", stored.ServiceName)
+	assert.NotNil(t, stored.EndDate)
+}
+
+func TestCreateSubscriptionIdempotencyKey(t *testing.T) {
+	r := setupTestRouter()
+
+	create := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/subs", bytes.NewBufferString(body))
+		req.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	body := `{"service_name": "Netflix", "price": 400, "user_id": "` + testUserID(20) + `", "start_date": "01-2025"}`
+
+	first := create("key-1", body)
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+	retry := create("key-1", body)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Header().Get("ETag"), retry.Header().Get("ETag"))
+	assert.JSONEq(t, first.Body.String(), retry.Body.String())
+
+	page, err := subSvc.List(context.Background(), model.ListFilter{UserID: testUserID(20)})
+	assert.NoError(t, err)
+	assert.Len(t, page.Items, 1)
+
+	w := create("key-1", `{"service_name": "Netflix", "price": 500, "user_id": "`+testUserID(20)+`", "start_date": "01-2025"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var p router.Problem
+	json.Unmarshal(w.Body.Bytes(), &p)
+	assert.Equal(t, "/problems/idempotency-key-reused", p.Type)
+
+	// Another key creates another subscription.
+	assert.Equal(t, http.StatusCreated, create("key-2", body).Code)
+	page, err = subSvc.List(context.Background(), model.ListFilter{UserID: testUserID(20)})
+	assert.NoError(t, err)
+	assert.Len(t, page.Items, 2)
+}
+
+func TestIdempotencyKeyReleasedAfterFailure(t *testing.T) {
+	r := setupTestRouter()
+
+	body := `{"service_name": "Netflix", "price": 400, "user_id": "` + testUserID(21) + `", "start_date": "01-2025"}`
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/subs", bytes.NewBufferString(body))
+		req.Header.Set("Idempotency-Key", "key-1")
+		return req
+	}
+
+	// The first attempt fails, its outcome must not be replayed.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, newRequest().WithContext(ctx))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, newRequest())
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+}
+
+// panickingSubService panics on the first Create and then delegates to the
+// embedded service.
+type panickingSubService struct {
+	router.SubService
+	panicked bool
+}
+
+func (s *panickingSubService) Create(ctx context.Context, sub *model.Subscription) error {
+	if !s.panicked {
+		s.panicked = true
+		panic("create failed")
+	}
+	return s.SubService.Create(ctx, sub)
+}
+
+func TestIdempotencyKeyReleasedAfterPanic(t *testing.T) {
+	setupTestRouter()
+	idempotency := router.IdempotencyConfig{Store: repository.NewMemoryIdempotencyStore(), TTL: time.Hour}
+	r := router.NewRouter(30*time.Second, &panickingSubService{SubService: subSvc}, newTestAPIKeyService(),
+		idempotency, middlewares.AuthConfig{Disabled: true}, nil, nil, zap.NewNop().Sugar())
+
+	body := `{"service_name": "Netflix", "price": 400, "user_id": "` + testUserID(22) + `", "start_date": "01-2025"}`
+	create := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/subs", bytes.NewBufferString(body))
+		req.Header.Set("Idempotency-Key", "key-1")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusInternalServerError, create().Code)
+	w := create()
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryIdempotencyStore()
+	rec := &model.IdempotencyRecord{Key: "key-1", RequestHash: "a", ExpiresAt: time.Now().Add(time.Hour)}
+
+	existing, err := store.Reserve(ctx, rec)
+	assert.NoError(t, err)
+	assert.Nil(t, existing)
+
+	// A reserved key without a response is reported as in progress.
+	existing, err = store.Reserve(ctx, &model.IdempotencyRecord{Key: "key-1", RequestHash: "a"})
+	assert.NoError(t, err)
+	if assert.NotNil(t, existing) {
+		assert.Zero(t, existing.Status)
+	}
+
+	rec.Status = http.StatusCreated
+	rec.Body = []byte(`{}`)
+	assert.NoError(t, store.Complete(ctx, rec))
+	existing, err = store.Reserve(ctx, &model.IdempotencyRecord{Key: "key-1", RequestHash: "a"})
+	assert.NoError(t, err)
+	if assert.NotNil(t, existing) {
+		assert.Equal(t, http.StatusCreated, existing.Status)
+		assert.Equal(t, []byte(`{}`), existing.Body)
+	}
+
+	n, err := store.PurgeExpired(ctx, time.Now().Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, n)
+	existing, err = store.Reserve(ctx, rec)
+	assert.NoError(t, err)
+	assert.Nil(t, existing)
+}
+
+func TestBatchSubscriptions(t *testing.T) {
+	r := setupTestRouter()
+	ctx := context.Background()
+
+	existing := make([]*model.Subscription, 2)
+	for i := range existing {
+		existing[i] = &model.Subscription{
+			ServiceName: "Netflix",
+			Price:       400,
+			UserID:      testUserID(22),
+			StartDate:   model.MonthYear{Time: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		}
+		assert.NoError(t, subSvc.Create(ctx, existing[i]))
+	}
+
+	batch := func(body string) (int, router.BatchResponse) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/subs/batch", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp router.BatchResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	statuses := func(resp router.BatchResponse) []int {
+		var res []int
+		for _, item := range resp.Results {
+			res = append(res, item.Status)
+		}
+		return res
+	}
+	count := func() int {
+		page, err := subSvc.List(ctx, model.ListFilter{UserID: testUserID(22)})
+		assert.NoError(t, err)
+		return len(page.Items)
+	}
+	create := `{"action": "create", "subscription": {"service_name": "Spotify", "price": 200, "user_id": "` +
+		testUserID(22) + `", "start_date": "02-2025"}}`
+
+	// An atomic batch with an invalid operation applies nothing.
+	code, resp := batch(`{"operations": [` + create + `,
+		{"action": "create", "subscription": {"service_name": "", "price": 200, "user_id": "` + testUserID(22) + `", "start_date": "02-2025"}},
+		{"action": "delete", "id": "` + existing[0].ID + `"}]}`)
+	assert.Equal(t, http.StatusMultiStatus, code)
+	assert.False(t, resp.Committed)
+	assert.Equal(t, []int{http.StatusFailedDependency, http.StatusBadRequest, http.StatusFailedDependency}, statuses(resp))
+	assert.Equal(t, "/api/v1/subs/batch#/operations/1", resp.Results[1].Error.Instance)
+	assert.Empty(t, resp.Results[0].ID)
+	assert.Equal(t, 2, count())
+
+	// So does one whose operation fails against the stored data.
+	code, resp = batch(`{"mode": "atomic", "operations": [` + create + `,
+		{"action": "delete", "id": "` + uuid.NewString() + `"}]}`)
+	assert.Equal(t, http.StatusMultiStatus, code)
+	assert.Equal(t, []int{http.StatusFailedDependency, http.StatusNotFound}, statuses(resp))
+	assert.Equal(t, 2, count())
+
+	code, resp = batch(`{"operations": [` + create + `, ` + create + `,
+		{"action": "update", "id": "` + existing[0].ID + `", "version": 1, "update": {"price": 450}},
+		{"action": "delete", "id": "` + existing[1].ID + `"}]}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, resp.Committed)
+	assert.Equal(t, []int{http.StatusCreated, http.StatusCreated, http.StatusOK, http.StatusNoContent}, statuses(resp))
+	assert.NotEmpty(t, resp.Results[0].ID)
+	assert.NotEqual(t, resp.Results[0].ID, resp.Results[1].ID)
+	assert.Equal(t, 450, resp.Results[2].Subscription.Price)
+	assert.Equal(t, 3, count())
+
+	events, err := subSvc.History(ctx, resp.Results[0].ID)
+	assert.NoError(t, err)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, model.EventCreate, events[0].Action)
+	}
+
+	// Best effort applies what it can.
+	code, resp = batch(`{"mode": "best_effort", "operations": [` + create + `,
+		{"action": "update", "id": "` + existing[0].ID + `", "version": 1, "update": {"price": 500}},
+		{"action": "archive", "id": "` + existing[0].ID + `"}]}`)
+	assert.Equal(t, http.StatusMultiStatus, code)
+	assert.True(t, resp.Committed)
+	assert.Equal(t, []int{http.StatusCreated, http.StatusPreconditionFailed, http.StatusBadRequest}, statuses(resp))
+	assert.Equal(t, 4, count())
+
+	code, _ = batch(`{"operations": []}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = batch(`{"mode": "eventually", "operations": [` + create + `]}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestImportSubscriptions(t *testing.T) {
+	r := setupTestRouter()
+	ctx := context.Background()
+
+	upload := func(query, contentType, body string) (int, router.ImportResponse) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/subs/import"+query, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp router.ImportResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	count := func() int {
+		page, err := subSvc.List(ctx, model.ListFilter{UserID: testUserID(23)})
+		assert.NoError(t, err)
+		return len(page.Items)
+	}
+	lines := func(resp router.ImportResponse) []int {
+		var res []int
+		for _, e := range resp.Errors {
+			res = append(res, e.Line)
+		}
+		return res
+	}
+
+	user := testUserID(23)
+	csvFile := "\ufeffservice_name,price,user_id,start_date,end_date\n" +
+		"Netflix,400," + user + ",01-2025,\n" +
+		"Spotify,abc," + user + ",01-2025,\n" +
+		"\"Yandex Plus\",300," + user + ",2025-01,\n" +
+		"Kinopoisk,250," + user + ",03-2025,12-2025\n" +
+		"Okko,100," + user + ",05-2025,01-2025\n"
+
+	code, resp := upload("?dry_run=true", "text/csv; charset=utf-8", csvFile)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, resp.DryRun)
+	assert.Equal(t, 5, resp.Total)
+	assert.Equal(t, 2, resp.Imported)
+	assert.Equal(t, 3, resp.Failed)
+	assert.Equal(t, []int{3, 4, 6}, lines(resp))
+	assert.Equal(t, "price", resp.Errors[0].Error.Errors[0].Field)
+	assert.Equal(t, "start_date", resp.Errors[1].Error.Errors[0].Field)
+	assert.Equal(t, "end_date", resp.Errors[2].Error.Errors[0].Field)
+	assert.Equal(t, 0, count())
+
+	// Fields that cannot be parsed are reported with the invalid ones.
+	code, resp = upload("?dry_run=true", "text/csv", "service_name,price,user_id,start_date\n,abc,not-a-uuid,13-2025\n")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []int{2}, lines(resp))
+	var fields []string
+	for _, f := range resp.Errors[0].Error.Errors {
+		fields = append(fields, f.Field)
+	}
+	assert.Equal(t, []string{"price", "start_date", "service_name", "user_id"}, fields)
+
+	code, resp = upload("", "text/csv", csvFile)
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, resp.DryRun)
+	assert.Equal(t, 2, resp.Imported)
+	assert.Equal(t, 2, count())
+
+	ndjson := `{"service_name": "Netflix", "price": 400, "user_id": "` + user + `", "start_date": "01-2025"}` + "\n" +
+		"\n" +
+		`{"service_name": "Spotify", "price": 200` + "\n" +
+		`{"service_name": "Okko", "price": 100, "user_id": "` + user + `", "start_date": "02-2025", "end_date": "04-2025"}` + "\n"
+	code, resp = upload("", "application/x-ndjson", ndjson)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 3, resp.Total)
+	assert.Equal(t, 2, resp.Imported)
+	assert.Equal(t, []int{3}, lines(resp))
+	assert.Equal(t, "/problems/malformed-request", resp.Errors[0].Error.Type)
+	assert.Equal(t, 4, count())
+
+	code, _ = upload("", "text/csv", "service_name,price\nNetflix,400\n")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = upload("", "application/json", "[]")
+	assert.Equal(t, http.StatusUnsupportedMediaType, code)
+}
+
+func TestExportSubscriptions(t *testing.T) {
+	r := setupTestRouter()
+	ctx := context.Background()
+
+	user := testUserID(24)
+	for i, name := range []string{"Netflix", "=HYPERLINK(\"x\")", "Yandex Plus"} {
+		sub := &model.Subscription{
+			ServiceName: name,
+			Price:       100 * (i + 1),
+			UserID:      user,
+			StartDate:   model.MonthYear{Time: time.Date(2025, time.Month(i+1), 1, 0, 0, 0, 0, time.UTC)},
+		}
+		assert.NoError(t, subSvc.Create(ctx, sub))
+	}
+
+	get := func(url, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// The limit applies to pages only, an export has every subscription.
+	w := get("/api/v1/subs?format=csv&sort=start_date&limit=1&user_id="+user, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename=subscriptions.csv`, w.Header().Get("Content-Disposition"))
+	rows := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if assert.Len(t, rows, 4) {
+		assert.Equal(t, "id,service_name,price,currency,billing_period,billing_interval,user_id,start_date,end_date,version,deleted_at", rows[0])
+		assert.Contains(t, rows[1], ",Netflix,100,RUB,month,1,"+user+",01-2025,,1,")
+		assert.Contains(t, rows[2], `,"'=HYPERLINK(""x"")",200,`)
+	}
+
+	w = get("/api/v1/subs?sort=-start_date&user_id="+user, "application/x-ndjson")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	rows = strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if assert.Len(t, rows, 3) {
+		var first map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(rows[0]), &first))
+		assert.Equal(t, "Yandex Plus", first["service_name"])
+		assert.Equal(t, float64(300), first["price"])
+		assert.Nil(t, first["end_date"])
+	}
+
+	w = get("/api/v1/subs?format=xlsx&user_id="+user, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if assert.NoError(t, err) {
+		var sheet []byte
+		for _, f := range zr.File {
+			if f.Name == "xl/worksheets/sheet1.xml" {
+				rc, err := f.Open()
+				assert.NoError(t, err)
+				sheet, _ = io.ReadAll(rc)
+				rc.Close()
+			}
+		}
+		assert.Contains(t, string(sheet), `<c r="B1" t="inlineStr"><is><t xml:space="preserve">service_name</t></is></c>`)
+		assert.Contains(t, string(sheet), `<c r="C4"><v>100</v></c>`)
+		assert.Contains(t, string(sheet), `=HYPERLINK(&#34;x&#34;)`)
+	}
+
+	w = get("/api/v1/subs/aggregate?from=01-2025&to=03-2025&group_by=service_name&format=csv&user_id="+user, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	rows = strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if assert.Len(t, rows, 4) {
+		assert.Equal(t, "service_name,total,count", rows[0])
+	}
+
+	w = get("/api/v1/subs/aggregate?from=01-2025&to=01-2025&user_id="+user, "text/csv")
+	assert.Equal(t, "total\n100\n", w.Body.String())
+
+	w = get("/api/v1/subs/aggregate/monthly?from=01-2025&to=02-2025&format=ndjson&user_id="+user, "")
+	assert.Equal(t, "{\"month\":\"01-2025\",\"total\":100,\"count\":1}\n{\"month\":\"02-2025\",\"total\":300,\"count\":2}\n", w.Body.String())
+
+	w = get("/api/v1/subs?format=pdf", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []string{"format"}, problemFields(t, w))
+
+	w = get("/api/v1/subs?format=csv&user_id=nope", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+}
+
+func TestSubscriptionBillingPeriod(t *testing.T) {
+	r := setupTestRouter()
+
+	user := testUserID(25)
+	create := func(payload map[string]interface{}) *httptest.ResponseRecorder {
+		payload["user_id"] = user
+		payload["start_date"] = "01-2025"
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/subs", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := create(map[string]interface{}{"service_name": "Netflix", "price": 300})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var monthly model.Subscription
+	json.Unmarshal(w.Body.Bytes(), &monthly)
+	assert.Equal(t, model.BillingMonth, monthly.BillingPeriod)
+	assert.Equal(t, 1, monthly.BillingInterval)
+
+	w = create(map[string]interface{}{"service_name": "Yandex Plus", "price": 1200, "billing_period": "year"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var yearly model.Subscription
+	json.Unmarshal(w.Body.Bytes(), &yearly)
+	assert.Equal(t, model.BillingYear, yearly.BillingPeriod)
+
+	w = create(map[string]interface{}{"service_name": "Okko", "price": 600, "billing_period": "month", "billing_interval": 6})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = create(map[string]interface{}{"service_name": "Kinopoisk", "price": 10, "billing_period": "week"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = create(map[string]interface{}{"service_name": "Ivi", "price": 10, "billing_period": "day", "billing_interval": -1})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []string{"billing_period", "billing_interval"}, problemFields(t, w))
+
+	get := func(url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url+"&user_id="+user, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// Monthly costs: 300, 1200/12 = 100, 600/6 = 100 and 10*52/12 = 43.33,
+	// which makes 520 over a year.
+	w = get("/api/v1/subs/aggregate?from=01-2025&to=12-2025")
+	var total map[string]int
+	json.Unmarshal(w.Body.Bytes(), &total)
+	assert.Equal(t, 12*500+520, total["total"])
+
+	w = get("/api/v1/subs/aggregate?from=01-2025&to=01-2025&mode=starts")
+	json.Unmarshal(w.Body.Bytes(), &total)
+	assert.Equal(t, 543, total["total"])
+
+	w = get("/api/v1/subs/aggregate/monthly?from=01-2025&to=01-2025")
+	var months []model.MonthlyAggregate
+	json.Unmarshal(w.Body.Bytes(), &months)
+	if assert.Len(t, months, 1) {
+		assert.Equal(t, 543, months[0].Total)
+		assert.Equal(t, 4, months[0].Count)
+	}
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/api/v1/subs/"+yearly.ID, bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	w = patch(`{"billing_interval": 0}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []string{"billing_interval"}, problemFields(t, w))
+
+	w = patch(`{"billing_period": "quarter", "billing_interval": 2}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &yearly)
+	assert.Equal(t, model.BillingQuarter, yearly.BillingPeriod)
+	assert.Equal(t, 2, yearly.BillingInterval)
+
+	// Null restores the default monthly billing.
+	w = patch(`{"billing_period": null, "billing_interval": null}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &yearly)
+	assert.Equal(t, model.BillingMonth, yearly.BillingPeriod)
+	assert.Equal(t, 1, yearly.BillingInterval)
+}
+
+func TestAggregateCurrencyConversion(t *testing.T) {
+	r := setupTestRouter()
+	ctx := context.Background()
+
+	user := testUserID(26)
+	month := func(m int) model.MonthYear {
+		return model.MonthYear{Time: time.Date(2025, time.Month(m), 1, 0, 0, 0, 0, time.UTC)}
+	}
+	feb, jan := month(2), month(1)
+	for _, sub := range []*model.Subscription{
+		{ServiceName: "Kinopoisk", Price: 100000, UserID: user, StartDate: month(1), EndDate: &feb},
+		{ServiceName: "Netflix", Price: 1000, Currency: "usd", UserID: user, StartDate: month(1), EndDate: &feb},
+		{ServiceName: "Crunchyroll", Price: 1000, Currency: "JPY", UserID: user, StartDate: month(1), EndDate: &jan},
+	} {
+		assert.NoError(t, subSvc.Create(ctx, sub))
+	}
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	total := func(w *httptest.ResponseRecorder) int {
+		var resp map[string]int
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp["total"]
+	}
+
+	w := do(http.MethodGet, "/api/v1/subs/aggregate?from=01-2025&to=02-2025&user_id="+user, "")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var problem router.Problem
+	json.Unmarshal(w.Body.Bytes(), &problem)
+	assert.Equal(t, "/problems/missing-currency-rates", problem.Type)
+	var missing []string
+	for _, m := range problem.MissingRates {
+		missing = append(missing, fmt.Sprintf("%s %s %d", m.Currency, m.Month.Format("01-2006"), m.Subscriptions))
+	}
+	assert.Equal(t, []string{"JPY 01-2025 1", "USD 01-2025 1", "USD 02-2025 1"}, missing)
+
+	w = do(http.MethodPut, "/api/v1/admin/currency-rates", `[
+		{"currency": "RUB", "month": "01-2025", "rate": 1},
+		{"currency": "USD", "month": "01-2025", "rate": 0}
+	]`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []string{"[0].currency", "[1].rate"}, problemFields(t, w))
+
+	w = do(http.MethodPut, "/api/v1/admin/currency-rates", `[
+		{"currency": "USD", "month": "01-2025", "rate": 80},
+		{"currency": "usd", "month": "01-2025", "rate": 90},
+		{"currency": "USD", "month": "02-2025", "rate": 100},
+		{"currency": "JPY", "month": "01-2025", "rate": 0.6}
+	]`)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = do(http.MethodGet, "/api/v1/admin/currency-rates?currency=usd", "")
+	var rates []model.CurrencyRate
+	json.Unmarshal(w.Body.Bytes(), &rates)
+	if assert.Len(t, rates, 2) {
+		assert.Equal(t, 90.0, rates[0].Rate)
+		assert.Equal(t, "02-2025", rates[1].Month.Format("01-2006"))
+	}
+
+	// In kopecks: 2 * 100000 for Kinopoisk, 10 USD at 90 and 100 for
+	// Netflix and 1000 JPY at 0.6 for Crunchyroll.
+	w = do(http.MethodGet, "/api/v1/subs/aggregate?from=01-2025&to=02-2025&user_id="+user, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 200000+90000+100000+60000, total(w))
+
+	// In cents: 1000 RUB and 600 RUB at 90 are 1111.11 and 666.67.
+	w = do(http.MethodGet, "/api/v1/subs/aggregate?from=01-2025&to=01-2025&currency=USD&user_id="+user, "")
+	assert.Equal(t, 1111+1000+667, total(w))
+
+	w = do(http.MethodGet, "/api/v1/subs/aggregate/monthly?from=02-2025&to=02-2025&currency=USD&user_id="+user, "")
+	var months []model.MonthlyAggregate
+	json.Unmarshal(w.Body.Bytes(), &months)
+	if assert.Len(t, months, 1) {
+		assert.Equal(t, 2000, months[0].Total)
+		assert.Equal(t, 2, months[0].Count)
+	}
+
+	w = do(http.MethodGet, "/api/v1/subs/aggregate?from=01-2025&to=01-2025&currency=EUR&user_id="+user, "")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	w = do(http.MethodGet, "/api/v1/subs/aggregate?from=01-2025&to=01-2025&currency=euro", "")
+	assert.Equal(t, []string{"currency"}, problemFields(t, w))
+}
+
+const testJWTSecret = "0123456789abcdef0123456789abcdef"
+
+// signTestToken returns a JWT with the given header and claims, signed by
+// sign over its encoded header and payload.
+func signTestToken(t *testing.T, header, claims map[string]interface{}, sign func(input []byte) []byte) string {
+	t.Helper()
+	segment := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		assert.NoError(t, err)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	input := segment(header) + "." + segment(claims)
+	return input + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(input)))
+}
+
+func hmacTestToken(t *testing.T, claims map[string]interface{}) string {
+	return signTestToken(t, map[string]interface{}{"alg": "HS256", "typ": "JWT"}, claims, func(input []byte) []byte {
+		mac := hmac.New(sha256.New, []byte(testJWTSecret))
+		mac.Write(input)
+		return mac.Sum(nil)
+	})
+}
+
+func TestAuthScopesDataToCaller(t *testing.T) {
+	verifier, err := middlewares.NewJWTVerifier(middlewares.JWTConfig{HMACSecret: testJWTSecret, Audience: "subs"})
+	assert.NoError(t, err)
+	r := setupTestRouterWithAuth(middlewares.AuthConfig{Verifier: verifier}, newTestAPIKeyService())
+
+	alice, bob := testUserID(27), testUserID(28)
+	exp := time.Now().Add(time.Hour).Unix()
+	aliceToken := hmacTestToken(t, map[string]interface{}{"sub": alice, "aud": "subs", "exp": exp})
+	adminToken := hmacTestToken(t, map[string]interface{}{"sub": "ops", "aud": []string{"subs"}, "exp": exp, "scope": "read admin"})
+
+	do := func(token, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	problemType := func(w *httptest.ResponseRecorder) string {
+		var problem router.Problem
+		json.Unmarshal(w.Body.Bytes(), &problem)
+		return problem.Type
+	}
+	create := func(token, userID string) model.Subscription {
+		w := do(token, http.MethodPost, "/api/v1/subs",
+			fmt.Sprintf(`{"service_name":"Netflix","price":500,"user_id":%q,"start_date":"07-2025"}`, userID))
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var sub model.Subscription
+		json.Unmarshal(w.Body.Bytes(), &sub)
+		return sub
+	}
+
+	for name, token := range map[string]string{
+		"missing":      "",
+		"expired":      hmacTestToken(t, map[string]interface{}{"sub": alice, "aud": "subs", "exp": time.Now().Add(-time.Hour).Unix()}),
+		"wrong secret": aliceToken[:strings.LastIndex(aliceToken, ".")+1] + "c2lnbmF0dXJl",
+		"audience":     hmacTestToken(t, map[string]interface{}{"sub": alice, "aud": "other", "exp": exp}),
+		"alg none":     strings.Join(strings.Split(aliceToken, ".")[:2], ".") + ".",
+		"subject":      hmacTestToken(t, map[string]interface{}{"sub": "alice@example.com", "aud": "subs", "exp": exp}),
+	} {
+		w := do(token, http.MethodGet, "/api/v1/subs", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code, name)
+		assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer", name)
+		assert.Equal(t, "/problems/unauthorized", problemType(w), name)
+	}
+
+	// The user_id of the body is replaced by the caller's own.
+	own := create(aliceToken, bob)
+	assert.Equal(t, alice, own.UserID)
+	other := create(adminToken, bob)
+	assert.Equal(t, bob, other.UserID)
+
+	w := do(aliceToken, http.MethodGet, "/api/v1/subs?user_id="+bob, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var page model.SubscriptionPage
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if assert.Len(t, page.Items, 1) {
+		assert.Equal(t, own.ID, page.Items[0].ID)
+	}
+
+	for _, req := range []struct{ method, url, body string }{
+		{http.MethodGet, "/api/v1/subs/" + other.ID, ""},
+		{http.MethodPatch, "/api/v1/subs/" + other.ID, `{"price":1}`},
+		{http.MethodDelete, "/api/v1/subs/" + other.ID, ""},
+		{http.MethodGet, "/api/v1/subs/" + other.ID + "/history", ""},
+	} {
+		w := do(aliceToken, req.method, req.url, req.body)
+		assert.Equal(t, http.StatusNotFound, w.Code, req.method+" "+req.url)
+	}
+	w = do(aliceToken, http.MethodPatch, "/api/v1/subs/"+own.ID, fmt.Sprintf(`{"user_id":%q}`, bob))
+	assert.Equal(t, http.StatusOK, w.Code)
+	sub, err := subSvc.Get(context.Background(), own.ID, false)
+	assert.NoError(t, err)
+	assert.Equal(t, alice, sub.UserID)
+
+	w = do(aliceToken, http.MethodGet, "/api/v1/subs/aggregate?from=07-2025&to=07-2025&user_id="+bob, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"total":500}`, w.Body.String())
+
+	w = do(aliceToken, http.MethodGet, "/api/v1/admin/currency-rates", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "/problems/forbidden", problemType(w))
+	w = do(adminToken, http.MethodGet, "/api/v1/admin/currency-rates", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = do(adminToken, http.MethodGet, "/api/v1/subs?user_id="+bob, "")
+	json.Unmarshal(w.Body.Bytes(), &page)
+	assert.Len(t, page.Items, 1)
+	w = do(adminToken, http.MethodGet, "/api/v1/subs/"+other.ID+"/history", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var events []model.SubscriptionEvent
+	json.Unmarshal(w.Body.Bytes(), &events)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "ops", events[0].Actor)
+	}
+}
+
+func TestIdempotencyKeyScopedToCaller(t *testing.T) {
+	verifier, err := middlewares.NewJWTVerifier(middlewares.JWTConfig{HMACSecret: testJWTSecret})
+	assert.NoError(t, err)
+	r := setupTestRouterWithAuth(middlewares.AuthConfig{Verifier: verifier}, newTestAPIKeyService())
+
+	exp := time.Now().Add(time.Hour).Unix()
+	for _, user := range []string{testUserID(30), testUserID(31)} {
+		body := `{"service_name": "Netflix", "price": 400, "start_date": "01-2025", "user_id": "` + user + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/subs", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+hmacTestToken(t, map[string]interface{}{"sub": user, "exp": exp}))
+		req.Header.Set("Idempotency-Key", "shared-key")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code, user)
+		assert.Empty(t, w.Header().Get("Idempotent-Replayed"), user)
+	}
+}
+
+func TestAuthJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+	}})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	assert.NoError(t, os.WriteFile(path, jwks, 0o600))
+	verifier, err := middlewares.NewJWTVerifier(middlewares.JWTConfig{JWKSFile: path, Issuer: "https://issuer.example"})
+	assert.NoError(t, err)
+	r := setupTestRouterWithAuth(middlewares.AuthConfig{Verifier: verifier}, newTestAPIKeyService())
+
+	claims := map[string]interface{}{"sub": testUserID(29), "iss": "https://issuer.example", "exp": time.Now().Add(time.Hour).Unix()}
+	signRSA := func(key *rsa.PrivateKey) func([]byte) []byte {
+		return func(input []byte) []byte {
+			digest := sha256.Sum256(input)
+			sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+			assert.NoError(t, err)
+			return sig
+		}
+	}
+	signEC := func(input []byte) []byte {
+		digest := sha256.Sum256(input)
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+		assert.NoError(t, err)
+		return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+
+	for name, tc := range map[string]struct {
+		token  string
+		status int
+	}{
+		"RS256":       {signTestToken(t, map[string]interface{}{"alg": "RS256", "kid": "rsa"}, claims, signRSA(rsaKey)), http.StatusOK},
+		"ES256":       {signTestToken(t, map[string]interface{}{"alg": "ES256", "kid": "ec"}, claims, signEC), http.StatusOK},
+		"unknown key": {signTestToken(t, map[string]interface{}{"alg": "RS256", "kid": "rsa"}, claims, signRSA(otherKey)), http.StatusUnauthorized},
+		"HS256":       {hmacTestToken(t, claims), http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/subs", nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, tc.status, w.Code, name)
+	}
+}
+
+func TestAuthFailsClosed(t *testing.T) {
+	r := setupTestRouterWithAuth(middlewares.AuthConfig{}, newTestAPIKeyService())
+	for _, url := range []string{"/api/v1/subs", "/api/v1/admin/currency-rates"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		assert.Equal(t, http.StatusUnauthorized, w.Code, url)
+	}
+
+	// Routes without the auth middleware in front are denied as well.
+	deny := middlewares.RequirePermission(model.PermissionRead, func(w http.ResponseWriter, r *http.Request, err error) {
+		assert.ErrorIs(t, err, middlewares.ErrUnauthorized)
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	w := httptest.NewRecorder()
+	deny(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestIncludeDeletedRequiresAdmin(t *testing.T) {
+	keys := newTestAPIKeyService()
+	const adminKey = "bootstrap-admin-key-0123456789abcdef"
+	assert.NoError(t, keys.BootstrapAPIKey(context.Background(), adminKey))
+	verifier, err := middlewares.NewJWTVerifier(middlewares.JWTConfig{HMACSecret: testJWTSecret})
+	assert.NoError(t, err)
+	r := setupTestRouterWithAuth(middlewares.AuthConfig{Verifier: verifier, APIKeys: keys}, keys)
+
+	user := testUserID(32)
+	sub := &model.Subscription{ServiceName: "Netflix", Price: 500, UserID: user,
+		StartDate: model.MonthYear{Time: time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)}}
+	assert.NoError(t, subSvc.Create(context.Background(), sub))
+	assert.NoError(t, subSvc.Delete(context.Background(), sub.ID, 0))
+
+	reader := &model.APIKey{Name: "support", Roles: model.Roles{model.RoleReader}}
+	readerKey, err := keys.CreateAPIKey(context.Background(), reader)
+	assert.NoError(t, err)
+	userToken := hmacTestToken(t, map[string]interface{}{"sub": user, "exp": time.Now().Add(time.Hour).Unix()})
+
+	urls := []string{
+		"/api/v1/subs/" + sub.ID + "?include_deleted=true",
+		"/api/v1/subs?include_deleted=true&user_id=" + user,
+		"/api/v1/subs/aggregate?from=07-2025&to=07-2025&include_deleted=true",
+		"/api/v1/subs/aggregate/monthly?from=07-2025&to=07-2025&include_deleted=true",
+	}
+	for _, url := range urls {
+		for name, tc := range map[string]struct {
+			header, value string
+			status        int
+		}{
+			"reader key": {middlewares.APIKeyHeader, readerKey, http.StatusForbidden},
+			"user token": {"Authorization", "Bearer " + userToken, http.StatusForbidden},
+			"admin key":  {middlewares.APIKeyHeader, adminKey, http.StatusOK},
+		} {
+			req := httptest.NewRequest(http.MethodGet, url, nil)
+			req.Header.Set(tc.header, tc.value)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tc.status, w.Code, name+" "+url)
+		}
+	}
+}
+
+func TestAPIKeyRoles(t *testing.T) {
+	keys := newTestAPIKeyService()
+	const adminKey = "bootstrap-admin-key-0123456789abcdef"
+	assert.NoError(t, keys.BootstrapAPIKey(context.Background(), adminKey))
+	assert.NoError(t, keys.BootstrapAPIKey(context.Background(), adminKey))
+	r := setupTestRouterWithAuth(middlewares.AuthConfig{APIKeys: keys}, keys)
+
+	ctx := context.Background()
+	july := model.MonthYear{Time: time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)}
+	var subs []*model.Subscription
+	for _, user := range []string{testUserID(30), testUserID(31)} {
+		sub := &model.Subscription{ServiceName: "Netflix", Price: 500, UserID: user, StartDate: july}
+		assert.NoError(t, subSvc.Create(ctx, sub))
+		subs = append(subs, sub)
+	}
+
+	do := func(key, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(middlewares.APIKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	create := func(name, roles string) router.CreatedAPIKey {
+		w := do(adminKey, http.MethodPost, "/api/v1/admin/api-keys", fmt.Sprintf(`{"name":%q,"roles":%s}`, name, roles))
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var created router.CreatedAPIKey
+		json.Unmarshal(w.Body.Bytes(), &created)
+		assert.True(t, strings.HasPrefix(created.Key, created.Prefix))
+		return created
+	}
+	support := create("support", `["reader"]`)
+	cron := create("billing-cron", `["writer","reader","writer"]`)
+	assert.Equal(t, model.Roles{model.RoleReader, model.RoleWriter}, cron.Roles)
+
+	w := do(adminKey, http.MethodPost, "/api/v1/admin/api-keys", `{"name":"","roles":["owner"]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.ElementsMatch(t, []string{"name", "roles[0]"}, problemFields(t, w))
+
+	// Readers aggregate across users but cannot change anything.
+	w = do(support.Key, http.MethodGet, "/api/v1/subs/aggregate?from=07-2025&to=07-2025", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"total":1000}`, w.Body.String())
+	for _, req := range []struct{ method, url string }{
+		{http.MethodDelete, "/api/v1/subs/" + subs[0].ID},
+		{http.MethodPost, "/api/v1/subs/batch"},
+		{http.MethodGet, "/api/v1/admin/currency-rates"},
+		{http.MethodGet, "/api/v1/admin/api-keys"},
+	} {
+		w = do(support.Key, req.method, req.url, "")
+		assert.Equal(t, http.StatusForbidden, w.Code, req.method+" "+req.url)
+	}
+
+	w = do(cron.Key, http.MethodDelete, "/api/v1/subs/"+subs[1].ID, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = do(cron.Key, http.MethodGet, "/api/v1/admin/currency-rates", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	events, err := subSvc.History(ctx, subs[1].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "api-key:billing-cron", events[len(events)-1].Actor)
+
+	w = do(adminKey, http.MethodGet, "/api/v1/admin/api-keys", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), support.Key)
+	var listed []model.APIKey
+	json.Unmarshal(w.Body.Bytes(), &listed)
+	assert.Len(t, listed, 3)
+
+	w = do(adminKey, http.MethodDelete, "/api/v1/admin/api-keys/"+support.ID, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = do(adminKey, http.MethodDelete, "/api/v1/admin/api-keys/"+support.ID, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = do(adminKey, http.MethodDelete, "/api/v1/admin/api-keys/not-a-uuid", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var problem router.Problem
+	json.Unmarshal(w.Body.Bytes(), &problem)
+	assert.Equal(t, `invalid api key id: "not-a-uuid"`, problem.Detail)
+	for _, key := range []string{support.Key, "sk_unknown", ""} {
+		w = do(key, http.MethodGet, "/api/v1/subs", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code, key)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	r := setupTestRouter()
+	ctx := context.Background()
+
+	user := testUserID(32)
+	month := func(m int) model.MonthYear {
+		return model.MonthYear{Time: time.Date(time.Now().Year(), time.Month(m), 1, 0, 0, 0, 0, time.UTC)}
+	}
+	now := month(int(time.Now().Month()))
+	ended := month(1).AddDate(-1, 0, 0)
+	subs := []*model.Subscription{
+		{ServiceName: "Netflix", Price: 500, UserID: user, StartDate: now},
+		{ServiceName: "Spotify", Price: 300, UserID: user, StartDate: model.MonthYear{Time: ended},
+			EndDate: &model.MonthYear{Time: ended}},
+		{ServiceName: "Kinopoisk", Price: 400, UserID: user, StartDate: now},
+	}
+	for _, sub := range subs {
+		assert.NoError(t, subSvc.Create(ctx, sub))
+	}
+	assert.NoError(t, subSvc.Delete(ctx, subs[2].ID, 0))
+
+	for _, url := range []string{"/api/v1/subs/" + subs[0].ID, "/api/v1/subs/" + uuid.NewString(), "/unknown/path"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, url, nil))
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	for _, line := range []string{
+		`http_requests_total{method="GET",route="/api/v1/subs/{id}",status="200"} 1`,
+		`http_requests_total{method="GET",route="/api/v1/subs/{id}",status="404"} 1`,
+		`http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="/api/v1/subs/{id}",status="200"} 1`,
+		`subscriptions_active 1`,
+		`subscriptions_stored 2`,
+		`subscriptions_deleted 1`,
+	} {
+		assert.Contains(t, body, line)
+	}
+
+	// The subscription gauges are cached between scrapes.
+	assert.NoError(t, subSvc.Create(ctx, &model.Subscription{ServiceName: "Okko", Price: 200, UserID: user, StartDate: now}))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, w.Body.String(), `subscriptions_stored 2`)
+}
+
+func TestTracing(t *testing.T) {
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+	_, err := tracing.Setup(context.Background(), tracing.Config{Exporter: tracing.ExporterNone})
+	assert.NoError(t, err)
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	r := setupTestRouter()
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/subs/aggregate?from=01-2025&to=12-2025&user_id="+testUserID(33), nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	server, ok := spans["GET /api/v1/subs/aggregate"]
+	if assert.True(t, ok, "request span is missing") {
+		assert.Equal(t, traceID, server.SpanContext().TraceID().String())
+		assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+		assert.Contains(t, server.Attributes(), attribute.Int("http.response.status_code", http.StatusOK))
+	}
+	svc, ok := spans["SubscriptionService.Aggregate"]
+	if assert.True(t, ok, "service span is missing") && server != nil {
+		assert.Equal(t, server.SpanContext().SpanID(), svc.Parent().SpanID())
+	}
+
+	// Failed service calls mark their span.
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/subs/"+uuid.NewString(), nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	var get sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == "SubscriptionService.Get" {
+			get = span
+		}
+	}
+	if assert.NotNil(t, get) {
+		assert.Equal(t, codes.Error, get.Status().Code)
+	}
+
+	// SQL queries get a span with their statement.
+	var qt tracing.QueryTracer
+	ctx := qt.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: "SELECT 1 FROM subscriptions"})
+	qt.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("SELECT 1")})
+	ended := recorder.Ended()
+	query := ended[len(ended)-1]
+	assert.Equal(t, "postgresql SELECT", query.Name())
+	assert.Equal(t, trace.SpanKindClient, query.SpanKind())
+	assert.Contains(t, query.Attributes(), attribute.String("db.statement", "SELECT 1 FROM subscriptions"))
+}
+
+func TestHealthProbes(t *testing.T) {
+	r := setupTestRouter()
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		assert.JSONEq(t, `{"status":"ok"}`, w.Body.String(), path)
+	}
+
+	var checkErr error
+	ready := func(ctx context.Context) error {
+		_, hasDeadline := ctx.Deadline()
+		assert.True(t, hasDeadline, "readiness check without a deadline")
+		return checkErr
+	}
+	logger := zap.NewNop().Sugar()
+	idempotency := router.IdempotencyConfig{Store: repository.NewMemoryIdempotencyStore(), TTL: time.Hour}
+	r = router.NewRouter(30*time.Second, subSvc, newTestAPIKeyService(), idempotency,
+		middlewares.AuthConfig{Disabled: true}, nil, ready, logger)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	checkErr = errors.New("schema is at migration 8, expected 9")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, `{"status":"unavailable","error":"schema is at migration 8, expected 9"}`, w.Body.String())
+
+	// Liveness does not depend on the readiness check.
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -5,42 +5,67 @@ import (
 	"log"
 	"net/http"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/DeneesK/sub-service/internal/model"
 	"github.com/DeneesK/sub-service/internal/router"
+	"github.com/DeneesK/sub-service/internal/router/middlewares"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
 const shutdownTimeout = time.Second * 1
 
 type SubService interface {
-	Create(sub *model.Subscription) error
-	Get(id string) (*model.Subscription, error)
-	List(userID string) ([]model.Subscription, error)
-	Update(id string, upd *model.UpdateSubscription) error
-	Delete(id string) error
-	Aggregate(from, to time.Time, userID, service string) (int, error)
+	Create(ctx context.Context, sub *model.Subscription) error
+	Get(ctx context.Context, id string, includeDeleted bool) (*model.Subscription, error)
+	List(ctx context.Context, f model.ListFilter) (*model.SubscriptionPage, error)
+	Export(ctx context.Context, f model.ListFilter, fn func(sub *model.Subscription) error) error
+	Update(ctx context.Context, id string, version int64, upd *model.UpdateSubscription) (*model.Subscription, error)
+	Delete(ctx context.Context, id string, version int64) error
+	Restore(ctx context.Context, id string) (*model.Subscription, error)
+	History(ctx context.Context, id string) ([]model.SubscriptionEvent, error)
+	Batch(ctx context.Context, mode model.BatchMode, ops []model.BatchOperation) (*model.BatchResult, error)
+	Import(ctx context.Context, src model.SubscriptionReader, dryRun bool) (*model.ImportResult, error)
+	Purge(ctx context.Context, retention time.Duration) (int64, error)
+	Aggregate(ctx context.Context, f model.AggregateFilter) (int, error)
+	AggregateMonthly(ctx context.Context, f model.AggregateFilter) ([]model.MonthlyAggregate, error)
+	AggregateGroups(ctx context.Context, f model.AggregateFilter) ([]model.AggregateGroup, error)
+	SetRates(ctx context.Context, rates []model.CurrencyRate) error
+	Rates(ctx context.Context, currency string) ([]model.CurrencyRate, error)
 }
 
 type APP struct {
-	srv        *http.Server
-	log        *zap.SugaredLogger
-	subService SubService
+	srv         *http.Server
+	log         *zap.SugaredLogger
+	subService  SubService
+	purge       PurgeConfig
+	idempotency router.IdempotencyConfig
+	health      HealthConfig
+	// shuttingDown is set on SIGTERM to fail the readiness probe.
+	shuttingDown atomic.Bool
 }
 
-func NewApp(addr string, timeOut time.Duration, log *zap.SugaredLogger, subService SubService) *APP {
-	r := router.NewRouter(timeOut, subService, log)
-	s := http.Server{
+func NewApp(
+	addr string, timeOut time.Duration, purge PurgeConfig, idempotency router.IdempotencyConfig,
+	auth middlewares.AuthConfig, metrics *prometheus.Registry, health HealthConfig,
+	log *zap.SugaredLogger, subService SubService, keyService router.APIKeyService,
+) *APP {
+	a := &APP{
+		log:         log,
+		subService:  subService,
+		purge:       purge,
+		idempotency: idempotency,
+		health:      health,
+	}
+	r := router.NewRouter(timeOut, subService, keyService, idempotency, auth, metrics, a.ready, log)
+	a.srv = &http.Server{
 		Addr:    addr,
 		Handler: r,
 	}
-	return &APP{
-		srv:        &s,
-		log:        log,
-		subService: subService,
-	}
+	return a
 }
 
 func (a *APP) Run() {
@@ -61,9 +86,12 @@ func (a *APP) Run() {
 		}
 	}()
 
+	go a.runPurge(ctx)
+
 	<-ctx.Done()
 
 	a.log.Infoln("application shutdown process...")
+	a.drain()
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 	if err := a.srv.Shutdown(shutdownCtx); err != nil {
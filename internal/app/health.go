@@ -0,0 +1,46 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/DeneesK/sub-service/internal/router"
+)
+
+// errShuttingDown reports an instance draining its connections before it
+// stops.
+var errShuttingDown = errors.New("instance is shutting down")
+
+// HealthConfig configures the readiness probe.
+type HealthConfig struct {
+	// Check tells whether the dependencies of the instance are available.
+	Check router.ReadinessCheck
+	// DrainDelay is how long the instance keeps serving after SIGTERM while
+	// reporting not ready, so load balancers stop routing to it before the
+	// server shuts down.
+	DrainDelay time.Duration
+}
+
+// ready reports the instance not ready once it is shutting down, and
+// otherwise runs the configured check.
+func (a *APP) ready(ctx context.Context) error {
+	if a.shuttingDown.Load() {
+		return errShuttingDown
+	}
+	if a.health.Check == nil {
+		return nil
+	}
+	return a.health.Check(ctx)
+}
+
+// drain marks the instance not ready and waits DrainDelay for load balancers
+// to notice.
+func (a *APP) drain() {
+	a.shuttingDown.Store(true)
+	if a.health.DrainDelay <= 0 {
+		return
+	}
+	a.log.Infof("not ready, draining for %s", a.health.DrainDelay)
+	time.Sleep(a.health.DrainDelay)
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"context"
+	"time"
+)
+
+// PurgeConfig configures hard deletion of soft deleted subscriptions.
+type PurgeConfig struct {
+	// Interval between purge runs, purging is disabled when zero.
+	Interval time.Duration
+	// Retention is how long deleted subscriptions can still be restored.
+	Retention time.Duration
+}
+
+// expiringStore is implemented by idempotency stores that have to drop
+// expired records explicitly.
+type expiringStore interface {
+	PurgeExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+// runPurge periodically hard deletes subscriptions deleted longer than the
+// retention period ago and expired idempotency records until ctx is done.
+func (a *APP) runPurge(ctx context.Context) {
+	if a.purge.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(a.purge.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := a.subService.Purge(ctx, a.purge.Retention); err != nil && ctx.Err() == nil {
+				a.log.Errorf("failed to purge deleted subscriptions: %s", err)
+			}
+			if store, ok := a.idempotency.Store.(expiringStore); ok {
+				if _, err := store.PurgeExpired(ctx, time.Now()); err != nil && ctx.Err() == nil {
+					a.log.Errorf("failed to purge expired idempotency keys: %s", err)
+				}
+			}
+		}
+	}
+}
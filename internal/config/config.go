@@ -9,16 +9,47 @@ import (
 )
 
 type Config struct {
-	ServerAddr    string        `envconfig:"SERVER_ADDR" default:"localhost:8080"`
-	TimeOut       time.Duration `envconfig:"TIMEOUT" default:"30s"`
-	DBHost        string        `envconfig:"DB_HOST" default:"localhost"`
-	DBPort        string        `envconfig:"DB_PORT" default:"5432"`
-	DBUser        string        `envconfig:"DB_USER" default:"postgres"`
-	DBPassword    string        `envconfig:"DB_PASSWORD" default:"BIGsecret"`
-	DBName        string        `envconfig:"DB_NAME" default:"subscriptions_db"`
-	DBSSLMode     string        `envconfig:"DB_SSLMODE" default:"disable"`
-	LogLevel      string        `envconfig:"LOG_LEVEL" default:"debug"`
-	MigrationPath string        `envconfig:"MIGRATION_PATH" default:"file://migrations"`
+	ServerAddr       string        `envconfig:"SERVER_ADDR" default:"localhost:8080"`
+	TimeOut          time.Duration `envconfig:"TIMEOUT" default:"30s"`
+	DBHost           string        `envconfig:"DB_HOST" default:"localhost"`
+	DBPort           string        `envconfig:"DB_PORT" default:"5432"`
+	DBUser           string        `envconfig:"DB_USER" default:"postgres"`
+	DBPassword       string        `envconfig:"DB_PASSWORD" default:"BIGsecret"`
+	DBName           string        `envconfig:"DB_NAME" default:"subscriptions_db"`
+	DBSSLMode        string        `envconfig:"DB_SSLMODE" default:"disable"`
+	LogLevel         string        `envconfig:"LOG_LEVEL" default:"debug"`
+	MigrationPath    string        `envconfig:"MIGRATION_PATH" default:"file://migrations"`
+	PurgeInterval    time.Duration `envconfig:"PURGE_INTERVAL" default:"1h"`
+	DeletedRetention time.Duration `envconfig:"DELETED_RETENTION" default:"720h"`
+	IdempotencyTTL   time.Duration `envconfig:"IDEMPOTENCY_TTL" default:"24h"`
+	// CurrencyRatesFile is a JSON file of currency rates loaded at start.
+	CurrencyRatesFile string `envconfig:"CURRENCY_RATES_FILE"`
+	// Bearer token authentication is enabled by a secret for HS* tokens
+	// and/or a JWKS file for RS*, PS* and ES* tokens.
+	JWTSecret     string `envconfig:"JWT_HMAC_SECRET"`
+	JWTJWKSFile   string `envconfig:"JWT_JWKS_FILE"`
+	JWTIssuer     string `envconfig:"JWT_ISSUER"`
+	JWTAudience   string `envconfig:"JWT_AUDIENCE"`
+	JWTAdminScope string `envconfig:"JWT_ADMIN_SCOPE" default:"admin"`
+	// APIKeysEnabled accepts the API keys of service accounts, AdminAPIKey
+	// is stored as an admin key at start to create the first ones.
+	APIKeysEnabled bool   `envconfig:"API_KEYS_ENABLED"`
+	AdminAPIKey    string `envconfig:"ADMIN_API_KEY"`
+	// AuthDisabled serves every request without authentication, the service
+	// refuses to start without an authenticator otherwise.
+	AuthDisabled bool `envconfig:"AUTH_DISABLED"`
+	// TracingExporter sends spans to the OTLP/HTTP collector at
+	// TracingOTLPEndpoint with otlp, prints them with stdout or drops them
+	// with none.
+	TracingExporter     string  `envconfig:"TRACING_EXPORTER" default:"none"`
+	TracingOTLPEndpoint string  `envconfig:"TRACING_OTLP_ENDPOINT"`
+	TracingSampleRatio  float64 `envconfig:"TRACING_SAMPLE_RATIO" default:"1"`
+	// ShutdownDrainDelay is how long /readyz fails before the server shuts
+	// down on SIGTERM.
+	ShutdownDrainDelay time.Duration `envconfig:"SHUTDOWN_DRAIN_DELAY" default:"5s"`
+	// SubscriptionStatsTTL is how long the subscription gauges of /metrics
+	// are served before they are queried again.
+	SubscriptionStatsTTL time.Duration `envconfig:"SUBSCRIPTION_STATS_TTL" default:"1m"`
 }
 
 func init() {
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/jmoiron/sqlx"
+)
+
+// LatestMigration returns the version of the last migration in
+// migrationSource, the version the schema is at once it is up to date.
+func LatestMigration(migrationSource string) (uint, error) {
+	src, err := source.Open(migrationSource)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	version, err := src.First()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, os.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		version = next
+	}
+}
+
+// NewReadinessCheck returns a check that pings db and verifies that the
+// migrations recorded by golang-migrate are at version latest and did not
+// fail halfway.
+func NewReadinessCheck(db *sqlx.DB, latest uint) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if err := db.PingContext(ctx); err != nil {
+			return fmt.Errorf("database is unreachable: %w", err)
+		}
+		var current struct {
+			Version uint `db:"version"`
+			Dirty   bool `db:"dirty"`
+		}
+		err := db.GetContext(ctx, &current, "SELECT version, dirty FROM schema_migrations LIMIT 1")
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("migrations are not applied, expected version %d", latest)
+		}
+		if err != nil {
+			return fmt.Errorf("read migration version: %w", err)
+		}
+		if current.Dirty {
+			return fmt.Errorf("migration %d failed and left the schema dirty", current.Version)
+		}
+		if current.Version != latest {
+			return fmt.Errorf("schema is at migration %d, expected %d", current.Version, latest)
+		}
+		return nil
+	}
+}
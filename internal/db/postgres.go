@@ -7,16 +7,21 @@ import (
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/jmoiron/sqlx"
+
+	"github.com/DeneesK/sub-service/internal/tracing"
 )
 
 func InitDBConnection(migrationSource, dbHost, dbPort, dbUser, dbPassword, dbName, sslMode string) (*sqlx.DB, error) {
 	dbDSN := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s", dbUser, dbPassword, dbHost, dbPort, dbName, sslMode)
-	db, err := sqlx.Open("pgx", dbDSN)
+	connConfig, err := pgx.ParseConfig(dbDSN)
 	if err != nil {
-		return nil, fmt.Errorf("sqlx.Open failed: %w", err)
+		return nil, fmt.Errorf("pgx.ParseConfig failed: %w", err)
 	}
+	connConfig.Tracer = tracing.QueryTracer{}
+	db := sqlx.NewDb(stdlib.OpenDB(*connConfig), "pgx")
 	if err := db.Ping(); err != nil {
 		return nil, fmt.Errorf("DB ping failed: %w", err)
 	}
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/DeneesK/sub-service/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// statsTimeout bounds the queries made for a scrape.
+const statsTimeout = 5 * time.Second
+
+// StatsSource provides the business figures exported as gauges.
+type StatsSource interface {
+	Stats(ctx context.Context) (*model.SubscriptionStats, error)
+}
+
+// SubscriptionCollector exports counts of subscriptions. They are queried
+// when a scrape finds them older than maxAge, so that frequent scrapes do not
+// load the database.
+type SubscriptionCollector struct {
+	src     StatsSource
+	maxAge  time.Duration
+	log     *zap.SugaredLogger
+	active  *prometheus.Desc
+	stored  *prometheus.Desc
+	deleted *prometheus.Desc
+
+	mu        sync.Mutex
+	stats     *model.SubscriptionStats
+	queriedAt time.Time
+}
+
+func NewSubscriptionCollector(src StatsSource, maxAge time.Duration, log *zap.SugaredLogger) *SubscriptionCollector {
+	return &SubscriptionCollector{
+		src:    src,
+		maxAge: maxAge,
+		log:    log,
+		active: prometheus.NewDesc("subscriptions_active",
+			"Subscriptions that are not deleted and cover the current month.", nil, nil),
+		stored: prometheus.NewDesc("subscriptions_stored",
+			"Subscriptions that are not deleted.", nil, nil),
+		deleted: prometheus.NewDesc("subscriptions_deleted",
+			"Soft deleted subscriptions waiting to be purged.", nil, nil),
+	}
+}
+
+func (c *SubscriptionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.active
+	ch <- c.stored
+	ch <- c.deleted
+}
+
+// Collect leaves the gauges out of the scrape when they cannot be queried,
+// so that the other metrics are still reported.
+func (c *SubscriptionCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := c.cachedStats()
+	if err != nil {
+		c.log.Errorf("failed to collect subscription stats: %s", err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.active, prometheus.GaugeValue, float64(stats.Active))
+	ch <- prometheus.MustNewConstMetric(c.stored, prometheus.GaugeValue, float64(stats.Total))
+	ch <- prometheus.MustNewConstMetric(c.deleted, prometheus.GaugeValue, float64(stats.Deleted))
+}
+
+// cachedStats returns the stats queried last unless they are older than
+// maxAge. Concurrent scrapes wait for a single query.
+func (c *SubscriptionCollector) cachedStats() (*model.SubscriptionStats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stats != nil && time.Since(c.queriedAt) < c.maxAge {
+		return c.stats, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), statsTimeout)
+	defer cancel()
+	stats, err := c.src.Stats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.stats, c.queriedAt = stats, time.Now()
+	return stats, nil
+}
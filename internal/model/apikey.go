@@ -0,0 +1,98 @@
+package model
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Permission is what a route requires from its caller.
+type Permission string
+
+const (
+	// PermissionRead allows reading and aggregating subscriptions.
+	PermissionRead Permission = "read"
+	// PermissionWrite allows creating, changing and deleting subscriptions.
+	PermissionWrite Permission = "write"
+	// PermissionAdmin allows managing currency rates and API keys.
+	PermissionAdmin Permission = "admin"
+)
+
+// Role is a set of permissions granted to a caller.
+type Role string
+
+const (
+	RoleReader Role = "reader"
+	RoleWriter Role = "writer"
+	RoleAdmin  Role = "admin"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleReader: {PermissionRead},
+	RoleWriter: {PermissionRead, PermissionWrite},
+	RoleAdmin:  {PermissionRead, PermissionWrite, PermissionAdmin},
+}
+
+func (r Role) IsValid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// Grants reports whether the role includes permission p.
+func (r Role) Grants(p Permission) bool {
+	return slices.Contains(rolePermissions[r], p)
+}
+
+// Roles is stored in a text[] column.
+type Roles []Role
+
+// Grants reports whether any of the roles includes permission p.
+func (rs Roles) Grants(p Permission) bool {
+	return slices.ContainsFunc(rs, func(r Role) bool { return r.Grants(p) })
+}
+
+func (rs Roles) Value() (driver.Value, error) {
+	names := make([]string, len(rs))
+	for i, r := range rs {
+		names[i] = string(r)
+	}
+	return "{" + strings.Join(names, ",") + "}", nil
+}
+
+// Scan reads the array literal of a text[] column, role names are plain
+// words so they are never quoted.
+func (rs *Roles) Scan(value interface{}) error {
+	var literal string
+	switch v := value.(type) {
+	case []byte:
+		literal = string(v)
+	case string:
+		literal = v
+	default:
+		return fmt.Errorf("cannot convert %T to Roles", value)
+	}
+	literal = strings.TrimSuffix(strings.TrimPrefix(literal, "{"), "}")
+	*rs = (*rs)[:0]
+	if literal == "" {
+		return nil
+	}
+	for _, name := range strings.Split(literal, ",") {
+		*rs = append(*rs, Role(name))
+	}
+	return nil
+}
+
+// APIKey swagger:model
+// APIKey authenticates a service account. Only a hash of the key is
+// stored, the key itself is shown once when it is created.
+type APIKey struct {
+	ID   string `db:"id" json:"id"`
+	Name string `db:"name" json:"name"`
+	// Prefix is the start of the key, to tell keys apart.
+	Prefix    string     `db:"prefix" json:"prefix"`
+	Roles     Roles      `db:"roles" json:"roles" swaggertype:"array,string" enums:"reader,writer,admin"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+	RevokedAt *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+}
@@ -0,0 +1,57 @@
+package model
+
+// BatchAction is the kind of a batch operation.
+type BatchAction string
+
+const (
+	BatchCreate BatchAction = "create"
+	BatchUpdate BatchAction = "update"
+	BatchDelete BatchAction = "delete"
+)
+
+// BatchMode selects what happens to a batch when some operations fail.
+type BatchMode string
+
+const (
+	// BatchAtomic applies either all operations or none of them.
+	BatchAtomic BatchMode = "atomic"
+	// BatchBestEffort applies every operation that succeeds on its own.
+	BatchBestEffort BatchMode = "best_effort"
+)
+
+// BatchOperation swagger:model
+type BatchOperation struct {
+	Action BatchAction `json:"action" enums:"create,update,delete"`
+	// ID of the subscription to update or delete.
+	ID string `json:"id,omitempty"`
+	// Version makes an update or delete conditional like If-Match does.
+	Version int64 `json:"version,omitempty"`
+	// Subscription to create.
+	Subscription *Subscription `json:"subscription,omitempty"`
+	// Update to apply.
+	Update *UpdateSubscription `json:"update,omitempty"`
+}
+
+// BatchRequest swagger:model
+type BatchRequest struct {
+	Mode       BatchMode        `json:"mode" enums:"atomic,best_effort" default:"atomic"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchItemResult is the outcome of a single batch operation.
+type BatchItemResult struct {
+	// Index of the operation in the batch.
+	Index  int
+	Action BatchAction
+	ID     string
+	// Subscription is the created or updated subscription.
+	Subscription *Subscription
+	Err          error
+}
+
+// BatchResult is the outcome of a batch, Committed tells whether the
+// successful operations were applied.
+type BatchResult struct {
+	Committed bool
+	Items     []BatchItemResult
+}
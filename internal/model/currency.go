@@ -0,0 +1,60 @@
+package model
+
+import "regexp"
+
+// DefaultCurrency is the currency of prices that do not name one and the
+// currency exchange rates are quoted in.
+const DefaultCurrency = "RUB"
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// IsCurrencyCode reports whether s looks like an ISO 4217 currency code.
+func IsCurrencyCode(s string) bool {
+	return currencyCode.MatchString(s)
+}
+
+// minorUnits lists the ISO 4217 currencies whose minor unit is not a
+// hundredth of the major one.
+var minorUnits = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"CLF": 4, "UYW": 4,
+}
+
+// MinorUnitExceptions returns the currencies whose minor unit is not a
+// hundredth with their number of decimal digits.
+func MinorUnitExceptions() map[string]int {
+	res := make(map[string]int, len(minorUnits))
+	for currency, n := range minorUnits {
+		res[currency] = n
+	}
+	return res
+}
+
+// MinorUnits returns the number of decimal digits between the major and the
+// minor unit of the currency, 2 for RUB where prices are kept in kopecks.
+func MinorUnits(currency string) int {
+	if n, ok := minorUnits[currency]; ok {
+		return n
+	}
+	return 2
+}
+
+// CurrencyRate swagger:model
+type CurrencyRate struct {
+	Currency string    `db:"currency" json:"currency" example:"USD"`
+	Month    MonthYear `db:"month" json:"month" swaggertype:"string" example:"01-2025"`
+	// Rate is the price of one unit of the currency in DefaultCurrency.
+	Rate float64 `db:"rate" json:"rate" example:"92.5"`
+}
+
+// MissingRate swagger:model
+type MissingRate struct {
+	// Currency whose rate for the month is missing.
+	Currency string    `db:"currency" json:"currency"`
+	Month    MonthYear `db:"month" json:"month" swaggertype:"string"`
+	// Subscriptions is the number of subscriptions that could not be
+	// converted because of it.
+	Subscriptions int `db:"subscriptions" json:"subscriptions"`
+}
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// EventAction is the kind of change recorded in the audit trail.
+type EventAction string
+
+const (
+	EventCreate  EventAction = "create"
+	EventUpdate  EventAction = "update"
+	EventDelete  EventAction = "delete"
+	EventRestore EventAction = "restore"
+)
+
+// SubscriptionEvent swagger:model
+type SubscriptionEvent struct {
+	ID             int64       `db:"id" json:"id"`
+	SubscriptionID string      `db:"subscription_id" json:"subscription_id"`
+	Action         EventAction `db:"action" json:"action"`
+	Actor          string      `db:"actor" json:"actor"`
+	RequestID      string      `db:"request_id" json:"request_id,omitempty"`
+	Before         JSONB       `db:"before" json:"before" swaggertype:"object"`
+	After          JSONB       `db:"after" json:"after" swaggertype:"object"`
+	// Diff maps every changed field to its {"from", "to"} values.
+	Diff      JSONB     `db:"diff" json:"diff" swaggertype:"object"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// JSONB is a raw JSON document stored in a jsonb column.
+// An empty JSONB is stored and encoded as null.
+type JSONB json.RawMessage
+
+func (j JSONB) MarshalJSON() ([]byte, error) {
+	if len(j) == 0 {
+		return []byte("null"), nil
+	}
+	return j, nil
+}
+
+func (j *JSONB) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*j = nil
+		return nil
+	}
+	*j = append((*j)[:0], data...)
+	return nil
+}
+
+func (j JSONB) Value() (driver.Value, error) {
+	if len(j) == 0 {
+		return nil, nil
+	}
+	return string(j), nil
+}
+
+func (j *JSONB) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*j = nil
+	case []byte:
+		*j = append((*j)[:0], v...)
+	case string:
+		*j = JSONB(v)
+	default:
+		return fmt.Errorf("cannot convert %T to JSONB", value)
+	}
+	return nil
+}
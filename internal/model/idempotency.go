@@ -0,0 +1,20 @@
+package model
+
+import "time"
+
+// IdempotencyRecord is the outcome of a request made with an idempotency
+// key, kept to answer retries of the request until it expires.
+type IdempotencyRecord struct {
+	// Subject is the caller that chose Key, keys of different callers do
+	// not collide.
+	Subject string `db:"subject"`
+	Key     string `db:"key"`
+	// RequestHash identifies the request the key was first used with.
+	RequestHash string `db:"request_hash"`
+	// Status is zero while the first request is still being processed.
+	Status    int       `db:"status"`
+	Header    JSONB     `db:"header"`
+	Body      []byte    `db:"body"`
+	CreatedAt time.Time `db:"created_at"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
@@ -0,0 +1,35 @@
+package model
+
+// ImportRow is a subscription read from an import file. Err is set instead
+// when the row could not be turned into a subscription. When only some of
+// its fields could not be parsed, Subscription holds the others and Err
+// lists the rejected ones, so that the row is validated as a whole.
+type ImportRow struct {
+	// Line is the line of the file the row starts at.
+	Line         int
+	Subscription *Subscription
+	Err          error
+}
+
+// SubscriptionReader reads the rows of an import file one by one and
+// returns io.EOF after the last one.
+type SubscriptionReader interface {
+	Read() (ImportRow, error)
+}
+
+// ImportLineError is the reason a line of an import file was rejected.
+type ImportLineError struct {
+	Line int
+	Err  error
+}
+
+// ImportResult summarizes an import. In a dry run Imported counts the rows
+// that passed validation, nothing is stored.
+type ImportResult struct {
+	DryRun   bool
+	Total    int
+	Imported int
+	Failed   int
+	// Errors lists the rejected lines, possibly truncated.
+	Errors []ImportLineError
+}
@@ -13,9 +13,17 @@ type MonthYear struct {
 
 const dataLayout = "01-2006" // MM-YYYY
 
+// ParseMonthYear parses a date in the MM-YYYY layout.
+func ParseMonthYear(s string) (MonthYear, error) {
+	t, err := time.Parse(dataLayout, s)
+	return MonthYear{Time: t}, err
+}
+
+// UnmarshalJSON leaves my zero for "", which stands for no date.
 func (my *MonthYear) UnmarshalJSON(data []byte) error {
 	s := strings.Trim(string(data), `"`)
 	if s == "" {
+		*my = MonthYear{}
 		return nil
 	}
 	t, err := time.Parse(dataLayout, s)
@@ -47,21 +55,212 @@ func (my *MonthYear) Scan(value interface{}) error {
 	return nil
 }
 
+// BillingPeriod is the unit of the billing cycle the price is charged for.
+type BillingPeriod string
+
+const (
+	BillingWeek    BillingPeriod = "week"
+	BillingMonth   BillingPeriod = "month"
+	BillingQuarter BillingPeriod = "quarter"
+	BillingYear    BillingPeriod = "year"
+)
+
+// DefaultBillingPeriod and DefaultBillingInterval are used when a
+// subscription does not specify its billing cycle.
+const (
+	DefaultBillingPeriod   = BillingMonth
+	DefaultBillingInterval = 1
+)
+
+// PerYear returns how many periods there are in a year, 0 for an unknown
+// period.
+func (p BillingPeriod) PerYear() int {
+	switch p {
+	case BillingWeek:
+		return 52
+	case BillingMonth:
+		return 12
+	case BillingQuarter:
+		return 4
+	case BillingYear:
+		return 1
+	}
+	return 0
+}
+
 // Subscription swagger:model
 type Subscription struct {
-	ID          string     `db:"id" json:"id"`
-	ServiceName string     `db:"service_name" json:"service_name"`
-	Price       int        `db:"price" json:"price"`
-	UserID      string     `db:"user_id" json:"user_id"`
-	StartDate   MonthYear  `db:"start_date" json:"start_date" swaggertype:"string"`
-	EndDate     *MonthYear `db:"end_date" json:"end_date,omitempty" swaggertype:"string"`
+	ID          string `db:"id" json:"id"`
+	ServiceName string `db:"service_name" json:"service_name"`
+	// Price is in minor units of Currency, kopecks for RUB and cents for USD.
+	Price     int        `db:"price" json:"price"`
+	Currency  string     `db:"currency" json:"currency" example:"RUB" default:"RUB"`
+	UserID    string     `db:"user_id" json:"user_id"`
+	StartDate MonthYear  `db:"start_date" json:"start_date" swaggertype:"string"`
+	EndDate   *MonthYear `db:"end_date" json:"end_date,omitempty" swaggertype:"string"`
+	DeletedAt *time.Time `db:"deleted_at" json:"deleted_at,omitempty"`
+	// Price is charged every BillingInterval BillingPeriods, every month by
+	// default.
+	BillingPeriod   BillingPeriod `db:"billing_period" json:"billing_period" enums:"week,month,quarter,year" default:"month"`
+	BillingInterval int           `db:"billing_interval" json:"billing_interval" default:"1"`
+	// Version is incremented by every change and served as the ETag.
+	Version int64 `db:"version" json:"version"`
 }
 
-// UpdateSubscription swagger:model
+// MonthlyCost returns the price normalized to a month, a 1200 per year plan
+// costs 100 a month. It is not rounded, so that costs are only rounded once
+// they are summed up.
+func (s *Subscription) MonthlyCost() float64 {
+	perYear := s.BillingPeriod.PerYear()
+	if perYear == 0 || s.BillingInterval <= 0 {
+		return 0
+	}
+	return float64(s.Price) * float64(perYear) / float64(12*s.BillingInterval)
+}
+
+// UpdateSubscription is a JSON Merge Patch (RFC 7396) of a subscription:
+// absent fields are kept and null removes the field. Null resets the
+// currency and the billing cycle to their defaults.
+// swagger:model
 type UpdateSubscription struct {
-	ServiceName *string    `db:"service_name" json:"service_name,omitempty"`
-	Price       *int       `db:"price" json:"price,omitempty"`
-	UserID      *string    `db:"user_id" json:"user_id,omitempty"`
-	StartDate   *MonthYear `db:"start_date" json:"start_date,omitempty" swaggertype:"string"`
-	EndDate     *MonthYear `db:"end_date" json:"end_date,omitempty" swaggertype:"string"`
+	ServiceName     Optional[string]        `json:"service_name" swaggertype:"string"`
+	Price           Optional[int]           `json:"price" swaggertype:"integer"`
+	Currency        Optional[string]        `json:"currency" swaggertype:"string"`
+	UserID          Optional[string]        `json:"user_id" swaggertype:"string"`
+	StartDate       Optional[MonthYear]     `json:"start_date" swaggertype:"string"`
+	EndDate         Optional[MonthYear]     `json:"end_date" swaggertype:"string"`
+	BillingPeriod   Optional[BillingPeriod] `json:"billing_period" swaggertype:"string" enums:"week,month,quarter,year"`
+	BillingInterval Optional[int]           `json:"billing_interval" swaggertype:"integer"`
+}
+
+// AggregateMode selects how subscription prices are summed over a period.
+type AggregateMode string
+
+const (
+	// AggregateProrated counts the monthly cost once for every month the
+	// subscription overlaps the requested period.
+	AggregateProrated AggregateMode = "prorated"
+	// AggregateStarts sums the monthly cost once for every subscription
+	// started within the requested period.
+	AggregateStarts AggregateMode = "starts"
+)
+
+// AggregateDimension is a subscription attribute aggregates can be grouped by.
+type AggregateDimension string
+
+const (
+	DimensionServiceName AggregateDimension = "service_name"
+	DimensionUserID      AggregateDimension = "user_id"
+)
+
+// AggregateFilter describes the period and filters of an aggregation query.
+type AggregateFilter struct {
+	From        time.Time
+	To          time.Time
+	UserID      string
+	ServiceName string
+	Mode        AggregateMode
+	GroupBy     []AggregateDimension
+	// Currency the costs are converted to at the rate of each month.
+	Currency string
+	// IncludeDeleted counts soft deleted subscriptions too.
+	IncludeDeleted bool
+}
+
+// SubscriptionStats counts the stored subscriptions.
+type SubscriptionStats struct {
+	// Active subscriptions are not deleted and cover the current month.
+	Active int64 `db:"active"`
+	// Total counts the subscriptions that are not deleted.
+	Total int64 `db:"total"`
+	// Deleted subscriptions wait to be purged.
+	Deleted int64 `db:"deleted"`
+}
+
+// MonthlyAggregate swagger:model
+type MonthlyAggregate struct {
+	Month MonthYear `db:"month" json:"month" swaggertype:"string"`
+	Total int       `db:"total" json:"total"`
+	Count int       `db:"count" json:"count"`
+}
+
+// AggregateGroup swagger:model
+type AggregateGroup struct {
+	Key   map[AggregateDimension]string `json:"key"`
+	Total int                           `json:"total"`
+	Count int                           `json:"count"`
+}
+
+// IsEmpty reports whether the update changes nothing.
+func (u *UpdateSubscription) IsEmpty() bool {
+	return !u.ServiceName.Set && !u.Price.Set && !u.Currency.Set && !u.UserID.Set && !u.StartDate.Set && !u.EndDate.Set &&
+		!u.BillingPeriod.Set && !u.BillingInterval.Set
+}
+
+// ApplyTo sets the fields present in the update on sub. Null clears the
+// field, which leaves the zero value in fields that are not nullable.
+func (u *UpdateSubscription) ApplyTo(sub *Subscription) {
+	if u.ServiceName.Set {
+		sub.ServiceName = u.ServiceName.Value
+	}
+	if u.Price.Set {
+		sub.Price = u.Price.Value
+	}
+	if u.Currency.Set {
+		sub.Currency = u.Currency.Value
+	}
+	if u.UserID.Set {
+		sub.UserID = u.UserID.Value
+	}
+	if u.StartDate.Set {
+		sub.StartDate = u.StartDate.Value
+	}
+	if u.EndDate.Set {
+		sub.EndDate = u.EndDate.Ptr()
+	}
+	if u.BillingPeriod.Set {
+		sub.BillingPeriod = u.BillingPeriod.Value
+	}
+	if u.BillingInterval.Set {
+		sub.BillingInterval = u.BillingInterval.Value
+	}
+}
+
+// ListSort is the order subscriptions are listed in.
+type ListSort string
+
+const (
+	SortStartDateAsc  ListSort = "start_date"
+	SortStartDateDesc ListSort = "-start_date"
+)
+
+// ListCursor is the keyset position of the last subscription of a page.
+type ListCursor struct {
+	StartDate time.Time `json:"s"`
+	ID        string    `json:"i"`
+	Sort      ListSort  `json:"o"`
+}
+
+// ListFilter selects a page of subscriptions.
+type ListFilter struct {
+	UserID      string
+	ServiceName string
+	// ActiveAt selects subscriptions active during the month.
+	ActiveAt *time.Time
+	PriceMin *int
+	PriceMax *int
+	Sort     ListSort
+	Limit    int
+	// Cursor is the opaque position returned with the previous page.
+	Cursor string
+	// After is the decoded Cursor, set by the service for repositories.
+	After *ListCursor
+	// IncludeDeleted lists soft deleted subscriptions too.
+	IncludeDeleted bool
+}
+
+// SubscriptionPage swagger:model
+type SubscriptionPage struct {
+	Items      []Subscription `json:"items"`
+	NextCursor string         `json:"next_cursor,omitempty"`
 }
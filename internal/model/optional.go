@@ -0,0 +1,56 @@
+package model
+
+import "encoding/json"
+
+// Optional is a field of a partial update. It is either unset, when the
+// field is absent from the input, explicitly null, or set to a value.
+type Optional[T any] struct {
+	// Set reports that the field was present, Null that it was null.
+	Set   bool
+	Null  bool
+	Value T
+}
+
+// Some returns an Optional set to v.
+func Some[T any](v T) Optional[T] {
+	return Optional[T]{Set: true, Value: v}
+}
+
+// Null returns an Optional explicitly set to null.
+func Null[T any]() Optional[T] {
+	return Optional[T]{Set: true, Null: true}
+}
+
+// UnmarshalJSON is only called for fields present in the input, so the
+// Optional becomes set. Values that decode to a zero value reported by
+// IsZero, like a MonthYear from "", count as null.
+func (o *Optional[T]) UnmarshalJSON(data []byte) error {
+	*o = Optional[T]{Set: true}
+	if string(data) == "null" {
+		o.Null = true
+		return nil
+	}
+	if err := json.Unmarshal(data, &o.Value); err != nil {
+		return err
+	}
+	if z, ok := any(o.Value).(interface{ IsZero() bool }); ok && z.IsZero() {
+		*o = Null[T]()
+	}
+	return nil
+}
+
+func (o Optional[T]) MarshalJSON() ([]byte, error) {
+	if !o.Set || o.Null {
+		return []byte("null"), nil
+	}
+	return json.Marshal(o.Value)
+}
+
+// Ptr returns a pointer to the value, nil if it is null or unset.
+func (o Optional[T]) Ptr() *T {
+	if !o.Set || o.Null {
+		return nil
+	}
+	v := o.Value
+	return &v
+}
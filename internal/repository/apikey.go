@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/DeneesK/sub-service/internal/model"
+	"github.com/DeneesK/sub-service/internal/service"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const apiKeyColumns = "id, name, prefix, roles, created_at, revoked_at"
+
+// PostgresAPIKeyRepository keeps API keys in PostgreSQL.
+type PostgresAPIKeyRepository struct {
+	db *sqlx.DB
+}
+
+func NewPostgresAPIKeyRepository(db *sqlx.DB) *PostgresAPIKeyRepository {
+	return &PostgresAPIKeyRepository{db: db}
+}
+
+func (r *PostgresAPIKeyRepository) CreateAPIKey(ctx context.Context, key *model.APIKey, hash string) error {
+	err := r.db.QueryRowxContext(ctx,
+		`INSERT INTO api_keys (name, prefix, key_hash, roles) VALUES ($1, $2, $3, $4)
+         RETURNING id, created_at`,
+		key.Name, key.Prefix, hash, key.Roles,
+	).Scan(&key.ID, &key.CreatedAt)
+	return translateError(err)
+}
+
+func (r *PostgresAPIKeyRepository) GetAPIKeyByHash(ctx context.Context, hash string) (*model.APIKey, error) {
+	var key model.APIKey
+	err := r.db.GetContext(ctx, &key, "SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash=$1", hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, service.ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &key, nil
+}
+
+func (r *PostgresAPIKeyRepository) ListAPIKeys(ctx context.Context) ([]model.APIKey, error) {
+	var keys []model.APIKey
+	err := r.db.SelectContext(ctx, &keys, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY created_at, id")
+	return keys, translateError(err)
+}
+
+func (r *PostgresAPIKeyRepository) RevokeAPIKey(ctx context.Context, id string) error {
+	res, err := r.db.ExecContext(ctx,
+		"UPDATE api_keys SET revoked_at=now() WHERE id=$1 AND revoked_at IS NULL", id)
+	if err = affectedOne(res, err); errors.Is(err, service.ErrNotFound) {
+		return service.ErrAPIKeyNotFound
+	}
+	return err
+}
+
+// MemoryAPIKeyRepository keeps API keys in memory.
+// It is meant for tests and local runs without a database.
+type MemoryAPIKeyRepository struct {
+	mu     sync.Mutex
+	keys   []model.APIKey
+	hashes map[string]int
+}
+
+func NewMemoryAPIKeyRepository() *MemoryAPIKeyRepository {
+	return &MemoryAPIKeyRepository{hashes: make(map[string]int)}
+}
+
+func (m *MemoryAPIKeyRepository) CreateAPIKey(ctx context.Context, key *model.APIKey, hash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.hashes[hash]; ok {
+		return service.ErrConflict
+	}
+	key.ID = uuid.New().String()
+	key.CreatedAt = time.Now()
+	stored := *key
+	stored.Roles = slices.Clone(key.Roles)
+	m.hashes[hash] = len(m.keys)
+	m.keys = append(m.keys, stored)
+	return nil
+}
+
+func (m *MemoryAPIKeyRepository) GetAPIKeyByHash(ctx context.Context, hash string) (*model.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.hashes[hash]
+	if !ok {
+		return nil, service.ErrAPIKeyNotFound
+	}
+	key := m.keys[i]
+	return &key, nil
+}
+
+func (m *MemoryAPIKeyRepository) ListAPIKeys(ctx context.Context) ([]model.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.keys), nil
+}
+
+func (m *MemoryAPIKeyRepository) RevokeAPIKey(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.keys {
+		if m.keys[i].ID == id && m.keys[i].RevokedAt == nil {
+			now := time.Now()
+			m.keys[i].RevokedAt = &now
+			return nil
+		}
+	}
+	return service.ErrAPIKeyNotFound
+}
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/DeneesK/sub-service/internal/service"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// PostgreSQL error codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgInvalidTextRepresentation = "22P02"
+	pgInvalidDatetimeFormat     = "22007"
+	pgNotNullViolation          = "23502"
+	pgForeignKeyViolation       = "23503"
+	pgUniqueViolation           = "23505"
+	pgCheckViolation            = "23514"
+)
+
+// translateError maps driver errors to the service errors.
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return service.ErrNotFound
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case pgUniqueViolation, pgForeignKeyViolation:
+			return fmt.Errorf("%w: %s", service.ErrConflict, pgErr.Message)
+		case pgInvalidTextRepresentation, pgInvalidDatetimeFormat, pgNotNullViolation, pgCheckViolation:
+			return fmt.Errorf("%w: %s", service.ErrValidation, pgErr.Message)
+		}
+	}
+	return err
+}
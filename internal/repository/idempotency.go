@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/DeneesK/sub-service/internal/model"
+	"github.com/DeneesK/sub-service/internal/service"
+	"github.com/jmoiron/sqlx"
+)
+
+// PostgresIdempotencyStore keeps idempotency records in PostgreSQL.
+type PostgresIdempotencyStore struct {
+	db *sqlx.DB
+}
+
+func NewPostgresIdempotencyStore(db *sqlx.DB) *PostgresIdempotencyStore {
+	return &PostgresIdempotencyStore{db: db}
+}
+
+// Reserve claims rec.Key of rec.Subject for the request described by rec.
+// If the key is already taken by a record that has not expired, that record
+// is returned and nothing is stored.
+func (s *PostgresIdempotencyStore) Reserve(ctx context.Context, rec *model.IdempotencyRecord) (*model.IdempotencyRecord, error) {
+	for {
+		var key string
+		err := s.db.QueryRowxContext(ctx,
+			`INSERT INTO idempotency_keys (subject, key, request_hash, expires_at) VALUES ($1, $2, $3, $4)
+             ON CONFLICT (subject, key) DO UPDATE
+                 SET request_hash = EXCLUDED.request_hash, status = 0, header = NULL, body = NULL,
+                     created_at = now(), expires_at = EXCLUDED.expires_at
+                 WHERE idempotency_keys.expires_at <= now()
+             RETURNING key`,
+			rec.Subject, rec.Key, rec.RequestHash, rec.ExpiresAt,
+		).Scan(&key)
+		if err == nil {
+			return nil, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, translateError(err)
+		}
+
+		var existing model.IdempotencyRecord
+		err = s.db.GetContext(ctx, &existing,
+			"SELECT * FROM idempotency_keys WHERE subject=$1 AND key=$2", rec.Subject, rec.Key)
+		if err == nil {
+			return &existing, nil
+		}
+		// The holder released the key after the insert conflicted with it,
+		// so it can be claimed again.
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, translateError(err)
+		}
+	}
+}
+
+// Complete stores the response of the request that reserved rec.Key.
+func (s *PostgresIdempotencyStore) Complete(ctx context.Context, rec *model.IdempotencyRecord) error {
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE idempotency_keys SET status=$3, header=$4, body=$5 WHERE subject=$1 AND key=$2",
+		rec.Subject, rec.Key, rec.Status, rec.Header, rec.Body)
+	return affectedOne(res, err)
+}
+
+// Release frees a reserved key so the request can be retried.
+func (s *PostgresIdempotencyStore) Release(ctx context.Context, subject, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE subject=$1 AND key=$2", subject, key)
+	return translateError(err)
+}
+
+// PurgeExpired removes records that expired before now.
+func (s *PostgresIdempotencyStore) PurgeExpired(ctx context.Context, now time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= $1", now)
+	if err != nil {
+		return 0, translateError(err)
+	}
+	return res.RowsAffected()
+}
+
+// MemoryIdempotencyStore keeps idempotency records in memory.
+// It is meant for tests and local runs without a database.
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[idempotencyKey]model.IdempotencyRecord
+}
+
+// idempotencyKey identifies a record of MemoryIdempotencyStore.
+type idempotencyKey struct {
+	subject, key string
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{records: make(map[idempotencyKey]model.IdempotencyRecord)}
+}
+
+func (s *MemoryIdempotencyStore) Reserve(ctx context.Context, rec *model.IdempotencyRecord) (*model.IdempotencyRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	k := idempotencyKey{rec.Subject, rec.Key}
+	if existing, ok := s.records[k]; ok && existing.ExpiresAt.After(now) {
+		return &existing, nil
+	}
+	s.records[k] = model.IdempotencyRecord{
+		Subject:     rec.Subject,
+		Key:         rec.Key,
+		RequestHash: rec.RequestHash,
+		CreatedAt:   now,
+		ExpiresAt:   rec.ExpiresAt,
+	}
+	return nil, nil
+}
+
+func (s *MemoryIdempotencyStore) Complete(ctx context.Context, rec *model.IdempotencyRecord) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := idempotencyKey{rec.Subject, rec.Key}
+	stored, ok := s.records[k]
+	if !ok {
+		return service.ErrNotFound
+	}
+	stored.Status = rec.Status
+	stored.Header = rec.Header
+	stored.Body = rec.Body
+	s.records[k] = stored
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(ctx context.Context, subject, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, idempotencyKey{subject, key})
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) PurgeExpired(ctx context.Context, now time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for key, rec := range s.records {
+		if !rec.ExpiresAt.After(now) {
+			delete(s.records, key)
+			n++
+		}
+	}
+	return n, nil
+}
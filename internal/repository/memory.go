@@ -0,0 +1,565 @@
+package repository
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/DeneesK/sub-service/internal/model"
+	"github.com/DeneesK/sub-service/internal/service"
+	"github.com/google/uuid"
+)
+
+var _ service.SubscriptionRepository = (*MemorySubscriptionRepository)(nil)
+
+// MemorySubscriptionRepository keeps subscriptions in memory.
+// It is meant for tests and local runs without a database.
+type MemorySubscriptionRepository struct {
+	mu     sync.RWMutex
+	data   map[string]model.Subscription
+	events []model.SubscriptionEvent
+	rates  map[rateKey]model.CurrencyRate
+	// txMu serializes transactions, changes made outside of InTx while a
+	// transaction is rolled back are lost.
+	txMu sync.Mutex
+}
+
+func NewMemorySubscriptionRepository() *MemorySubscriptionRepository {
+	return &MemorySubscriptionRepository{
+		data:  make(map[string]model.Subscription),
+		rates: make(map[rateKey]model.CurrencyRate),
+	}
+}
+
+func (m *MemorySubscriptionRepository) Create(ctx context.Context, sub *model.Subscription) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sub.ID = uuid.New().String()
+	sub.Version = 1
+	m.data[sub.ID] = *sub
+	return nil
+}
+
+func (m *MemorySubscriptionRepository) CreateMany(ctx context.Context, subs []*model.Subscription) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.ID = uuid.New().String()
+		sub.Version = 1
+		m.data[sub.ID] = *sub
+	}
+	return nil
+}
+
+func (m *MemorySubscriptionRepository) Get(ctx context.Context, id string, includeDeleted bool) (*model.Subscription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sub, ok := m.data[id]
+	if !ok || sub.DeletedAt != nil && !includeDeleted {
+		return nil, service.ErrNotFound
+	}
+	return &sub, nil
+}
+
+func (m *MemorySubscriptionRepository) List(ctx context.Context, f model.ListFilter) ([]model.Subscription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	asc := f.Sort == model.SortStartDateAsc
+	less := func(a, b model.Subscription) bool {
+		if !a.StartDate.Equal(b.StartDate.Time) {
+			return a.StartDate.Before(b.StartDate.Time) == asc
+		}
+		return a.ID != b.ID && (a.ID < b.ID) == asc
+	}
+
+	var res []model.Subscription
+	for _, sub := range m.data {
+		if !matchesList(sub, f) {
+			continue
+		}
+		if f.After != nil && !less(model.Subscription{
+			ID:        f.After.ID,
+			StartDate: model.MonthYear{Time: f.After.StartDate},
+		}, sub) {
+			continue
+		}
+		res = append(res, sub)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return less(res[i], res[j])
+	})
+	if f.Limit > 0 && len(res) > f.Limit {
+		res = res[:f.Limit]
+	}
+	return res, nil
+}
+
+func (m *MemorySubscriptionRepository) Stream(ctx context.Context, f model.ListFilter, fn func(sub *model.Subscription) error) error {
+	subs, err := m.List(ctx, f)
+	if err != nil {
+		return err
+	}
+	for i := range subs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(&subs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemorySubscriptionRepository) Update(ctx context.Context, id string, version int64, upd *model.UpdateSubscription) (*model.Subscription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sub, ok := m.data[id]
+	if !ok || sub.DeletedAt != nil {
+		return nil, service.ErrNotFound
+	}
+	if sub.Version != version {
+		return nil, service.ErrPreconditionFailed
+	}
+
+	if upd.IsEmpty() {
+		return &sub, nil
+	}
+	upd.ApplyTo(&sub)
+	sub.Version++
+	m.data[id] = sub
+	return &sub, nil
+}
+
+func (m *MemorySubscriptionRepository) Delete(ctx context.Context, id string, version int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sub, ok := m.data[id]
+	if !ok || sub.DeletedAt != nil {
+		return service.ErrNotFound
+	}
+	if sub.Version != version {
+		return service.ErrPreconditionFailed
+	}
+	now := time.Now()
+	sub.DeletedAt = &now
+	sub.Version++
+	m.data[id] = sub
+	return nil
+}
+
+func (m *MemorySubscriptionRepository) Restore(ctx context.Context, id string) (*model.Subscription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sub, ok := m.data[id]
+	if !ok || sub.DeletedAt == nil {
+		return nil, service.ErrNotFound
+	}
+	sub.DeletedAt = nil
+	sub.Version++
+	m.data[id] = sub
+	return &sub, nil
+}
+
+func (m *MemorySubscriptionRepository) AddEvents(ctx context.Context, events ...*model.SubscriptionEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range events {
+		e.ID = int64(len(m.events) + 1)
+		e.CreatedAt = time.Now()
+		m.events = append(m.events, *e)
+	}
+	return nil
+}
+
+func (m *MemorySubscriptionRepository) ListEvents(ctx context.Context, subscriptionID string) ([]model.SubscriptionEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var res []model.SubscriptionEvent
+	for _, e := range m.events {
+		if e.SubscriptionID == subscriptionID {
+			res = append(res, e)
+		}
+	}
+	return res, nil
+}
+
+// InTx runs fn against m and restores the previous state if fn fails.
+func (m *MemorySubscriptionRepository) InTx(ctx context.Context, fn func(repo service.SubscriptionRepository) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+
+	m.mu.RLock()
+	data := make(map[string]model.Subscription, len(m.data))
+	for id, sub := range m.data {
+		data[id] = sub
+	}
+	events := len(m.events)
+	m.mu.RUnlock()
+
+	if err := fn(memoryTx{m}); err != nil {
+		m.mu.Lock()
+		m.data = data
+		m.events = m.events[:events]
+		m.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// memoryTx is the repository passed to InTx callbacks, nested transactions
+// join the outer one.
+type memoryTx struct {
+	*MemorySubscriptionRepository
+}
+
+func (t memoryTx) InTx(ctx context.Context, fn func(repo service.SubscriptionRepository) error) error {
+	return fn(t)
+}
+
+func (m *MemorySubscriptionRepository) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for id, sub := range m.data {
+		if sub.DeletedAt != nil && sub.DeletedAt.Before(deletedBefore) {
+			delete(m.data, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemorySubscriptionRepository) Stats(ctx context.Context, month time.Time) (*model.SubscriptionStats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var stats model.SubscriptionStats
+	for _, sub := range m.data {
+		if sub.DeletedAt != nil {
+			stats.Deleted++
+			continue
+		}
+		stats.Total++
+		if !sub.StartDate.After(month) && (sub.EndDate == nil || !sub.EndDate.Before(month)) {
+			stats.Active++
+		}
+	}
+	return &stats, nil
+}
+
+func (m *MemorySubscriptionRepository) Aggregate(ctx context.Context, f model.AggregateFilter) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var sum float64
+	for _, c := range m.costs(f) {
+		sum += c.amount
+	}
+	return int(math.Round(sum)), nil
+}
+
+func (m *MemorySubscriptionRepository) AggregateGroups(ctx context.Context, f model.AggregateFilter) ([]model.AggregateGroup, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	type group struct {
+		key   map[model.AggregateDimension]string
+		total float64
+		subs  map[string]bool
+	}
+	groups := make(map[string]*group)
+	var order []string
+	for _, c := range m.costs(f) {
+		key := make(map[model.AggregateDimension]string, len(f.GroupBy))
+		var id string
+		for _, d := range f.GroupBy {
+			switch d {
+			case model.DimensionServiceName:
+				key[d] = c.sub.ServiceName
+			case model.DimensionUserID:
+				key[d] = c.sub.UserID
+			}
+			id += key[d] + "\x00"
+		}
+		g, ok := groups[id]
+		if !ok {
+			g = &group{key: key, subs: make(map[string]bool)}
+			groups[id] = g
+			order = append(order, id)
+		}
+		g.total += c.amount
+		g.subs[c.sub.ID] = true
+	}
+
+	sort.Strings(order)
+	res := make([]model.AggregateGroup, 0, len(order))
+	for _, id := range order {
+		g := groups[id]
+		res = append(res, model.AggregateGroup{Key: g.key, Total: int(math.Round(g.total)), Count: len(g.subs)})
+	}
+	return res, nil
+}
+
+func (m *MemorySubscriptionRepository) AggregateMonthly(ctx context.Context, f model.AggregateFilter) ([]model.MonthlyAggregate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	f.Mode = model.AggregateProrated
+	totals := make(map[string]float64)
+	counts := make(map[string]int)
+	for _, c := range m.costs(f) {
+		totals[monthKey(c.month)] += c.amount
+		counts[monthKey(c.month)]++
+	}
+
+	var res []model.MonthlyAggregate
+	for month := f.From; !month.After(f.To); month = month.AddDate(0, 1, 0) {
+		res = append(res, model.MonthlyAggregate{
+			Month: model.MonthYear{Time: month},
+			Total: int(math.Round(totals[monthKey(month)])),
+			Count: counts[monthKey(month)],
+		})
+	}
+	return res, nil
+}
+
+func (m *MemorySubscriptionRepository) MissingRates(ctx context.Context, f model.AggregateFilter) ([]model.MissingRate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	type key struct {
+		currency string
+		month    string
+	}
+	subs := make(map[key]map[string]bool)
+	var res []model.MissingRate
+	for _, c := range m.costs(f) {
+		if c.missing == "" {
+			continue
+		}
+		k := key{c.missing, monthKey(c.month)}
+		if subs[k] == nil {
+			subs[k] = make(map[string]bool)
+			res = append(res, model.MissingRate{Currency: c.missing, Month: model.MonthYear{Time: c.month}})
+		}
+		subs[k][c.sub.ID] = true
+	}
+	for i := range res {
+		res[i].Subscriptions = len(subs[key{res[i].Currency, monthKey(res[i].Month.Time)}])
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].Month.Equal(res[j].Month.Time) {
+			return res[i].Month.Before(res[j].Month.Time)
+		}
+		return res[i].Currency < res[j].Currency
+	})
+	return res, nil
+}
+
+func (m *MemorySubscriptionRepository) SetRates(ctx context.Context, rates []model.CurrencyRate) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, rate := range rates {
+		m.rates[rateKey{rate.Currency, monthKey(rate.Month.Time)}] = rate
+	}
+	return nil
+}
+
+func (m *MemorySubscriptionRepository) ListRates(ctx context.Context, currency string) ([]model.CurrencyRate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var res []model.CurrencyRate
+	for _, rate := range m.rates {
+		if currency == "" || rate.Currency == currency {
+			res = append(res, rate)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Currency != res[j].Currency {
+			return res[i].Currency < res[j].Currency
+		}
+		return res[i].Month.Before(res[j].Month.Time)
+	})
+	return res, nil
+}
+
+// rateKey identifies the rate of a currency in a month.
+type rateKey struct {
+	currency string
+	month    string
+}
+
+func monthKey(t time.Time) string {
+	return t.Format("2006-01")
+}
+
+// cost is the monthly cost of a subscription in one of the months it is
+// counted for by an aggregate.
+type cost struct {
+	sub    model.Subscription
+	month  time.Time
+	amount float64
+	// missing names the currency whose rate is missing, amount is zero then.
+	missing string
+}
+
+// costs returns the costs of the subscriptions selected by f converted to
+// f.Currency at the rates of their months, like the costs of the Postgres
+// repository. The caller holds m.mu.
+func (m *MemorySubscriptionRepository) costs(f model.AggregateFilter) []cost {
+	var res []cost
+	for _, sub := range m.data {
+		if !matchesAggregate(sub, f) {
+			continue
+		}
+		first, last := maxTime(sub.StartDate.Time, f.From), f.To
+		if sub.EndDate != nil {
+			last = minTime(sub.EndDate.Time, f.To)
+		}
+		if f.Mode == model.AggregateStarts {
+			first, last = sub.StartDate.Time, sub.StartDate.Time
+		}
+		for month := first; !month.After(last); month = month.AddDate(0, 1, 0) {
+			c := cost{sub: sub, month: month, amount: sub.MonthlyCost()}
+			if sub.Currency != f.Currency {
+				from, fromOK := m.rate(sub.Currency, month)
+				to, toOK := m.rate(f.Currency, month)
+				switch {
+				case !fromOK:
+					c.missing, c.amount = sub.Currency, 0
+				case !toOK:
+					c.missing, c.amount = f.Currency, 0
+				default:
+					c.amount *= from / to * math.Pow10(model.MinorUnits(f.Currency)-model.MinorUnits(sub.Currency))
+				}
+			}
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// rate returns the price of a unit of the currency in the default currency
+// in the month.
+func (m *MemorySubscriptionRepository) rate(currency string, month time.Time) (float64, bool) {
+	if currency == model.DefaultCurrency {
+		return 1, true
+	}
+	rate, ok := m.rates[rateKey{currency, monthKey(month)}]
+	return rate.Rate, ok
+}
+
+func matchesList(sub model.Subscription, f model.ListFilter) bool {
+	switch {
+	case sub.DeletedAt != nil && !f.IncludeDeleted:
+		return false
+	case f.UserID != "" && sub.UserID != f.UserID:
+		return false
+	case f.ServiceName != "" && sub.ServiceName != f.ServiceName:
+		return false
+	case f.ActiveAt != nil && (sub.StartDate.After(*f.ActiveAt) || sub.EndDate != nil && sub.EndDate.Before(*f.ActiveAt)):
+		return false
+	case f.PriceMin != nil && sub.Price < *f.PriceMin:
+		return false
+	case f.PriceMax != nil && sub.Price > *f.PriceMax:
+		return false
+	}
+	return true
+}
+
+// matchesAggregate reports whether sub is counted by an aggregate over f.
+func matchesAggregate(sub model.Subscription, f model.AggregateFilter) bool {
+	switch {
+	case sub.DeletedAt != nil && !f.IncludeDeleted:
+		return false
+	case f.UserID != "" && sub.UserID != f.UserID:
+		return false
+	case f.ServiceName != "" && sub.ServiceName != f.ServiceName:
+		return false
+	case f.Mode == model.AggregateStarts:
+		return !sub.StartDate.Before(f.From) && !sub.StartDate.After(f.To)
+	}
+	return !sub.StartDate.After(f.To) && (sub.EndDate == nil || !sub.EndDate.Before(f.From))
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,582 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DeneesK/sub-service/internal/model"
+	"github.com/DeneesK/sub-service/internal/service"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+var _ service.SubscriptionRepository = (*PostgresSubscriptionRepository)(nil)
+
+// PostgresSubscriptionRepository stores subscriptions in PostgreSQL.
+type PostgresSubscriptionRepository struct {
+	db *sqlx.DB
+	// q runs the queries, it is either db or the transaction of InTx.
+	q sqlx.ExtContext
+}
+
+func NewPostgresSubscriptionRepository(db *sqlx.DB) *PostgresSubscriptionRepository {
+	return &PostgresSubscriptionRepository{db: db, q: db}
+}
+
+// InTx runs fn with a repository bound to a single transaction, which is
+// committed if fn succeeds and rolled back otherwise. Nested calls join the
+// outer transaction.
+func (r *PostgresSubscriptionRepository) InTx(ctx context.Context, fn func(repo service.SubscriptionRepository) error) error {
+	if _, ok := r.q.(*sqlx.Tx); ok {
+		return fn(r)
+	}
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return translateError(err)
+	}
+	if err := fn(&PostgresSubscriptionRepository{db: r.db, q: tx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return translateError(tx.Commit())
+}
+
+func (r *PostgresSubscriptionRepository) Create(ctx context.Context, sub *model.Subscription) error {
+	query := `INSERT INTO subscriptions (service_name, price, currency, user_id, start_date, end_date, billing_period, billing_interval)
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, version`
+	err := r.q.QueryRowxContext(
+		ctx, query,
+		sub.ServiceName, sub.Price, sub.Currency, sub.UserID, sub.StartDate, sub.EndDate, sub.BillingPeriod, sub.BillingInterval,
+	).Scan(&sub.ID, &sub.Version)
+	return translateError(err)
+}
+
+// CreateMany inserts the subscriptions with multi-row inserts. The ids are
+// generated here, so they do not depend on the order rows are returned in.
+func (r *PostgresSubscriptionRepository) CreateMany(ctx context.Context, subs []*model.Subscription) error {
+	for len(subs) > 0 {
+		chunk := subs[:min(len(subs), insertChunkSize)]
+		subs = subs[len(chunk):]
+
+		byID := make(map[string]*model.Subscription, len(chunk))
+		values := make([]string, len(chunk))
+		args := make([]interface{}, 0, len(chunk)*9)
+		for i, sub := range chunk {
+			sub.ID = uuid.NewString()
+			byID[sub.ID] = sub
+			values[i] = placeholders(len(args), 9)
+			args = append(args, sub.ID, sub.ServiceName, sub.Price, sub.Currency, sub.UserID, sub.StartDate, sub.EndDate,
+				sub.BillingPeriod, sub.BillingInterval)
+		}
+		rows, err := r.q.QueryContext(ctx,
+			`INSERT INTO subscriptions (id, service_name, price, currency, user_id, start_date, end_date, billing_period, billing_interval)
+             VALUES `+strings.Join(values, ", ")+` RETURNING id, version`,
+			args...)
+		if err != nil {
+			return translateError(err)
+		}
+		for rows.Next() {
+			var id string
+			var version int64
+			if err := rows.Scan(&id, &version); err != nil {
+				rows.Close()
+				return translateError(err)
+			}
+			if sub, ok := byID[id]; ok {
+				sub.Version = version
+			}
+		}
+		if err := rows.Close(); err != nil {
+			return translateError(err)
+		}
+		if err := rows.Err(); err != nil {
+			return translateError(err)
+		}
+	}
+	return nil
+}
+
+func (r *PostgresSubscriptionRepository) Get(ctx context.Context, id string, includeDeleted bool) (*model.Subscription, error) {
+	query := "SELECT * FROM subscriptions WHERE id=$1"
+	if !includeDeleted {
+		query += " AND deleted_at IS NULL"
+	}
+	var sub model.Subscription
+	err := sqlx.GetContext(ctx, r.q, &sub, query, id)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &sub, nil
+}
+
+func (r *PostgresSubscriptionRepository) List(ctx context.Context, f model.ListFilter) ([]model.Subscription, error) {
+	query, args := listQuery(f)
+	var subs []model.Subscription
+	err := sqlx.SelectContext(ctx, r.q, &subs, query, args...)
+	return subs, translateError(err)
+}
+
+// streamFetchSize is the number of rows fetched from the cursor of Stream at
+// a time.
+const streamFetchSize = 500
+
+// Stream passes the subscriptions matching f to fn one by one. They are read
+// through a server-side cursor, so only a few of them are held in memory.
+func (r *PostgresSubscriptionRepository) Stream(ctx context.Context, f model.ListFilter, fn func(sub *model.Subscription) error) error {
+	query, args := listQuery(f)
+	// A cursor lives until the end of its transaction.
+	return r.InTx(ctx, func(repo service.SubscriptionRepository) error {
+		q := repo.(*PostgresSubscriptionRepository).q
+		if _, err := q.ExecContext(ctx, "DECLARE subscriptions_stream NO SCROLL CURSOR FOR "+query, args...); err != nil {
+			return translateError(err)
+		}
+
+		fetch := fmt.Sprintf("FETCH %d FROM subscriptions_stream", streamFetchSize)
+		for {
+			var subs []model.Subscription
+			if err := sqlx.SelectContext(ctx, q, &subs, fetch); err != nil {
+				return translateError(err)
+			}
+			for i := range subs {
+				if err := fn(&subs[i]); err != nil {
+					return err
+				}
+			}
+			if len(subs) < streamFetchSize {
+				return nil
+			}
+		}
+	})
+}
+
+// listQuery builds the query of List, f.Limit of zero lists every matching
+// subscription.
+func listQuery(f model.ListFilter) (string, []interface{}) {
+	var where []string
+	if !f.IncludeDeleted {
+		where = append(where, "deleted_at IS NULL")
+	}
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + fmt.Sprint(len(args))
+	}
+
+	if f.UserID != "" {
+		where = append(where, "user_id = "+arg(f.UserID))
+	}
+	if f.ServiceName != "" {
+		where = append(where, "service_name = "+arg(f.ServiceName))
+	}
+	if f.ActiveAt != nil {
+		month := arg(*f.ActiveAt)
+		where = append(where, "start_date <= "+month, "(end_date IS NULL OR end_date >= "+month+")")
+	}
+	if f.PriceMin != nil {
+		where = append(where, "price >= "+arg(*f.PriceMin))
+	}
+	if f.PriceMax != nil {
+		where = append(where, "price <= "+arg(*f.PriceMax))
+	}
+
+	cmp, order := "<", "DESC"
+	if f.Sort == model.SortStartDateAsc {
+		cmp, order = ">", "ASC"
+	}
+	if f.After != nil {
+		where = append(where, fmt.Sprintf("(start_date, id) %s (%s, %s)", cmp, arg(f.After.StartDate), arg(f.After.ID)))
+	}
+
+	query := `SELECT * FROM subscriptions`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf(` ORDER BY start_date %[1]s, id %[1]s`, order)
+	if f.Limit > 0 {
+		query += ` LIMIT ` + arg(f.Limit)
+	}
+	return query, args
+}
+
+func (r *PostgresSubscriptionRepository) Update(ctx context.Context, id string, version int64, upd *model.UpdateSubscription) (*model.Subscription, error) {
+	setClauses := []string{}
+	args := map[string]interface{}{"id": id, "version": version}
+
+	set := func(column string, present bool, value interface{}) {
+		if present {
+			setClauses = append(setClauses, column+"=:"+column)
+			args[column] = value
+		}
+	}
+	set("service_name", upd.ServiceName.Set, upd.ServiceName.Ptr())
+	set("price", upd.Price.Set, upd.Price.Ptr())
+	set("currency", upd.Currency.Set, upd.Currency.Ptr())
+	set("user_id", upd.UserID.Set, upd.UserID.Ptr())
+	set("start_date", upd.StartDate.Set, upd.StartDate.Ptr())
+	set("end_date", upd.EndDate.Set, upd.EndDate.Ptr())
+	set("billing_period", upd.BillingPeriod.Set, upd.BillingPeriod.Ptr())
+	set("billing_interval", upd.BillingInterval.Set, upd.BillingInterval.Ptr())
+
+	if len(setClauses) == 0 {
+		sub, err := r.Get(ctx, id, false)
+		if err == nil && sub.Version != version {
+			return nil, service.ErrPreconditionFailed
+		}
+		return sub, err
+	}
+	setClauses = append(setClauses, "version=version+1")
+
+	query := fmt.Sprintf(`UPDATE subscriptions SET %s WHERE id=:id AND version=:version AND deleted_at IS NULL RETURNING *`,
+		strings.Join(setClauses, ", "))
+	query, bound, err := r.q.BindNamed(query, args)
+	if err != nil {
+		return nil, err
+	}
+	var sub model.Subscription
+	err = sqlx.GetContext(ctx, r.q, &sub, query, bound...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, r.staleOrMissing(ctx, id)
+	}
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &sub, nil
+}
+
+func (r *PostgresSubscriptionRepository) Delete(ctx context.Context, id string, version int64) error {
+	res, err := r.q.ExecContext(ctx,
+		`UPDATE subscriptions SET deleted_at = now(), version = version + 1
+         WHERE id=$1 AND version=$2 AND deleted_at IS NULL`, id, version)
+	return r.affectedVersion(ctx, id, res, err)
+}
+
+func (r *PostgresSubscriptionRepository) Restore(ctx context.Context, id string) (*model.Subscription, error) {
+	var sub model.Subscription
+	err := sqlx.GetContext(ctx, r.q, &sub,
+		`UPDATE subscriptions SET deleted_at = NULL, version = version + 1
+         WHERE id=$1 AND deleted_at IS NOT NULL RETURNING *`, id)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &sub, nil
+}
+
+// AddEvents stores the events with multi-row inserts and fills in their ids
+// and creation time.
+func (r *PostgresSubscriptionRepository) AddEvents(ctx context.Context, events ...*model.SubscriptionEvent) error {
+	for len(events) > 0 {
+		chunk := events[:min(len(events), insertChunkSize)]
+		events = events[len(chunk):]
+
+		values := make([]string, len(chunk))
+		args := make([]interface{}, 0, len(chunk)*7)
+		for i, e := range chunk {
+			values[i] = placeholders(len(args), 7)
+			args = append(args, e.SubscriptionID, e.Action, e.Actor, e.RequestID, e.Before, e.After, e.Diff)
+		}
+		// The rows of a multi-row insert are returned in the order of VALUES.
+		rows, err := r.q.QueryContext(ctx,
+			`INSERT INTO subscription_events (subscription_id, action, actor, request_id, before, after, diff)
+             VALUES `+strings.Join(values, ", ")+` RETURNING id, created_at`,
+			args...)
+		if err != nil {
+			return translateError(err)
+		}
+		for i := 0; rows.Next(); i++ {
+			if err := rows.Scan(&chunk[i].ID, &chunk[i].CreatedAt); err != nil {
+				rows.Close()
+				return translateError(err)
+			}
+		}
+		if err := rows.Close(); err != nil {
+			return translateError(err)
+		}
+		if err := rows.Err(); err != nil {
+			return translateError(err)
+		}
+	}
+	return nil
+}
+
+func (r *PostgresSubscriptionRepository) ListEvents(ctx context.Context, subscriptionID string) ([]model.SubscriptionEvent, error) {
+	var events []model.SubscriptionEvent
+	err := sqlx.SelectContext(ctx, r.q, &events,
+		"SELECT * FROM subscription_events WHERE subscription_id=$1 ORDER BY id", subscriptionID)
+	return events, translateError(err)
+}
+
+func (r *PostgresSubscriptionRepository) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	res, err := r.q.ExecContext(ctx, "DELETE FROM subscriptions WHERE deleted_at < $1", deletedBefore)
+	if err != nil {
+		return 0, translateError(err)
+	}
+	return res.RowsAffected()
+}
+
+// insertChunkSize is the number of rows inserted by a single statement,
+// which keeps the number of parameters below the PostgreSQL limit.
+const insertChunkSize = 1000
+
+// placeholders returns "($n+1, ..., $n+count)".
+func placeholders(n, count int) string {
+	ps := make([]string, count)
+	for i := range ps {
+		ps[i] = "$" + strconv.Itoa(n+i+1)
+	}
+	return "(" + strings.Join(ps, ", ") + ")"
+}
+
+// affectedOne reports ErrNotFound when a statement matched no rows.
+func affectedOne(res sql.Result, err error) error {
+	if err != nil {
+		return translateError(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return service.ErrNotFound
+	}
+	return nil
+}
+
+// affectedVersion is affectedOne for statements conditioned on the version
+// of subscription id, it tells a changed version from a missing subscription.
+func (r *PostgresSubscriptionRepository) affectedVersion(ctx context.Context, id string, res sql.Result, err error) error {
+	err = affectedOne(res, err)
+	if !errors.Is(err, service.ErrNotFound) {
+		return err
+	}
+	return r.staleOrMissing(ctx, id)
+}
+
+// staleOrMissing explains why a statement conditioned on the version of
+// subscription id matched no rows.
+func (r *PostgresSubscriptionRepository) staleOrMissing(ctx context.Context, id string) error {
+	var exists bool
+	err := sqlx.GetContext(ctx, r.q, &exists,
+		"SELECT EXISTS (SELECT 1 FROM subscriptions WHERE id=$1 AND deleted_at IS NULL)", id)
+	if err != nil {
+		return translateError(err)
+	}
+	if exists {
+		return service.ErrPreconditionFailed
+	}
+	return service.ErrNotFound
+}
+
+func (r *PostgresSubscriptionRepository) Stats(ctx context.Context, month time.Time) (*model.SubscriptionStats, error) {
+	var stats model.SubscriptionStats
+	err := sqlx.GetContext(ctx, r.q, &stats,
+		`SELECT COUNT(*) FILTER (WHERE deleted_at IS NULL AND start_date <= $1
+                                   AND (end_date IS NULL OR end_date >= $1)) AS active,
+                COUNT(*) FILTER (WHERE deleted_at IS NULL) AS total,
+                COUNT(*) FILTER (WHERE deleted_at IS NOT NULL) AS deleted
+         FROM subscriptions`, month)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &stats, nil
+}
+
+func (r *PostgresSubscriptionRepository) Aggregate(ctx context.Context, f model.AggregateFilter) (int, error) {
+	costs, args := aggregateCosts(f)
+	q := costs + ` SELECT COALESCE(ROUND(SUM(cost)),0)::bigint FROM costs`
+	var sum int
+	err := sqlx.GetContext(ctx, r.q, &sum, q, args...)
+	return sum, translateError(err)
+}
+
+func (r *PostgresSubscriptionRepository) AggregateGroups(ctx context.Context, f model.AggregateFilter) ([]model.AggregateGroup, error) {
+	cols := make([]string, 0, len(f.GroupBy))
+	for _, d := range f.GroupBy {
+		switch d {
+		case model.DimensionServiceName:
+			cols = append(cols, "service_name")
+		case model.DimensionUserID:
+			cols = append(cols, "user_id")
+		default:
+			return nil, fmt.Errorf("unknown group by dimension %q", d)
+		}
+	}
+
+	costs, args := aggregateCosts(f)
+	q := costs + ` SELECT ` + strings.Join(cols, ", ") + `,
+                 COALESCE(ROUND(SUM(cost)),0)::bigint, COUNT(DISTINCT id)
+          FROM costs
+          GROUP BY ` + strings.Join(cols, ", ") + `
+          ORDER BY ` + strings.Join(cols, ", ")
+	rows, err := r.q.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	res := []model.AggregateGroup{}
+	for rows.Next() {
+		keys := make([]string, len(f.GroupBy))
+		dest := make([]interface{}, 0, len(keys)+2)
+		for i := range keys {
+			dest = append(dest, &keys[i])
+		}
+		g := model.AggregateGroup{Key: make(map[model.AggregateDimension]string, len(keys))}
+		dest = append(dest, &g.Total, &g.Count)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		for i, d := range f.GroupBy {
+			g.Key[d] = keys[i]
+		}
+		res = append(res, g)
+	}
+	return res, translateError(rows.Err())
+}
+
+func (r *PostgresSubscriptionRepository) AggregateMonthly(ctx context.Context, f model.AggregateFilter) ([]model.MonthlyAggregate, error) {
+	f.Mode = model.AggregateProrated
+	costs, args := aggregateCosts(f)
+	q := costs + ` SELECT m.month::date AS month, COALESCE(ROUND(SUM(c.cost)),0)::bigint AS total, COUNT(c.id) AS count
+          FROM generate_series(
+              date_trunc('month', $1::date), date_trunc('month', $2::date), interval '1 month'
+          ) AS m(month)
+          LEFT JOIN costs c ON c.month = m.month::date
+          GROUP BY m.month ORDER BY m.month`
+	var res []model.MonthlyAggregate
+	err := sqlx.SelectContext(ctx, r.q, &res, q, args...)
+	return res, translateError(err)
+}
+
+func (r *PostgresSubscriptionRepository) MissingRates(ctx context.Context, f model.AggregateFilter) ([]model.MissingRate, error) {
+	costs, args := aggregateCosts(f)
+	q := costs + ` SELECT missing AS currency, month, COUNT(DISTINCT id) AS subscriptions
+          FROM costs WHERE missing IS NOT NULL
+          GROUP BY missing, month ORDER BY month, missing`
+	var res []model.MissingRate
+	err := sqlx.SelectContext(ctx, r.q, &res, q, args...)
+	return res, translateError(err)
+}
+
+func (r *PostgresSubscriptionRepository) SetRates(ctx context.Context, rates []model.CurrencyRate) error {
+	for len(rates) > 0 {
+		chunk := rates[:min(len(rates), insertChunkSize)]
+		rates = rates[len(chunk):]
+
+		values := make([]string, len(chunk))
+		args := make([]interface{}, 0, len(chunk)*3)
+		for i, rate := range chunk {
+			values[i] = placeholders(len(args), 3)
+			args = append(args, rate.Currency, rate.Month, rate.Rate)
+		}
+		_, err := r.q.ExecContext(ctx,
+			`INSERT INTO currency_rates (currency, month, rate) VALUES `+strings.Join(values, ", ")+`
+             ON CONFLICT (currency, month) DO UPDATE SET rate = EXCLUDED.rate`,
+			args...)
+		if err != nil {
+			return translateError(err)
+		}
+	}
+	return nil
+}
+
+func (r *PostgresSubscriptionRepository) ListRates(ctx context.Context, currency string) ([]model.CurrencyRate, error) {
+	query := `SELECT currency, month, rate::float8 AS rate FROM currency_rates`
+	var args []interface{}
+	if currency != "" {
+		query += ` WHERE currency = $1`
+		args = append(args, currency)
+	}
+	query += ` ORDER BY currency, month`
+	var rates []model.CurrencyRate
+	err := sqlx.SelectContext(ctx, r.q, &rates, query, args...)
+	return rates, translateError(err)
+}
+
+// aggregateCosts returns a WITH clause defining costs: the monthly cost of
+// every subscription selected by f in every month it is counted for,
+// converted to f.Currency at the rates of the month. Where a rate is
+// missing, cost is NULL and missing names the currency lacking it.
+func aggregateCosts(f model.AggregateFilter) (string, []interface{}) {
+	where, args := aggregateConditions(f)
+	args = append(args, f.Currency)
+	target := "$" + strconv.Itoa(len(args)) + "::text"
+
+	months := `GREATEST(s.start_date, $1::date), LEAST(COALESCE(s.end_date, $2::date), $2::date)`
+	if f.Mode == model.AggregateStarts {
+		months = `s.start_date, s.start_date`
+	}
+	// Rates are quoted in the default currency, which has none itself.
+	from := `CASE WHEN s.currency = '` + model.DefaultCurrency + `' THEN 1 ELSE rf.rate END`
+	to := `CASE WHEN ` + target + ` = '` + model.DefaultCurrency + `' THEN 1 ELSE rt.rate END`
+	scale := fmt.Sprintf(`power(10::numeric, %d - %s)`, model.MinorUnits(f.Currency), minorUnitsSQL("s.currency"))
+
+	return `WITH costs AS (
+    SELECT s.id, s.service_name, s.user_id::text AS user_id, m.month::date AS month,
+           ` + monthlyCost + ` * CASE WHEN s.currency = ` + target + ` THEN 1
+               ELSE ` + from + ` / ` + to + ` * ` + scale + ` END AS cost,
+           CASE WHEN s.currency = ` + target + ` THEN NULL
+                WHEN (` + from + `) IS NULL THEN s.currency
+                WHEN (` + to + `) IS NULL THEN ` + target + ` END AS missing
+    FROM subscriptions s
+    CROSS JOIN LATERAL generate_series(` + months + `, interval '1 month') AS m(month)
+    LEFT JOIN currency_rates rf ON rf.currency = s.currency AND rf.month = m.month::date
+    LEFT JOIN currency_rates rt ON rt.currency = ` + target + ` AND rt.month = m.month::date
+    WHERE ` + strings.Join(where, " AND ") + `
+)`, args
+}
+
+// aggregateConditions returns the WHERE conditions selecting subscriptions
+// counted by the filter together with their positional arguments.
+func aggregateConditions(f model.AggregateFilter) ([]string, []interface{}) {
+	var where []string
+	switch f.Mode {
+	case model.AggregateStarts:
+		where = []string{"s.start_date >= $1", "s.start_date <= $2"}
+	default:
+		where = []string{"s.start_date <= $2", "(s.end_date IS NULL OR s.end_date >= $1)"}
+	}
+	if !f.IncludeDeleted {
+		where = append(where, "s.deleted_at IS NULL")
+	}
+	args := []interface{}{f.From, f.To}
+	if f.UserID != "" {
+		where = append(where, "s.user_id = $"+fmt.Sprint(len(args)+1))
+		args = append(args, f.UserID)
+	}
+	if f.ServiceName != "" {
+		where = append(where, "s.service_name = $"+fmt.Sprint(len(args)+1))
+		args = append(args, f.ServiceName)
+	}
+	return where, args
+}
+
+// monthlyCost is the expression of the price of s normalized to a month,
+// like model.Subscription.MonthlyCost.
+const monthlyCost = `s.price::numeric * CASE s.billing_period
+        WHEN 'week' THEN 52 WHEN 'month' THEN 12 WHEN 'quarter' THEN 4 WHEN 'year' THEN 1
+    END / (12 * s.billing_interval)`
+
+// minorUnitsSQL returns the expression of model.MinorUnits of the currency
+// column.
+func minorUnitsSQL(column string) string {
+	exceptions := model.MinorUnitExceptions()
+	currencies := make([]string, 0, len(exceptions))
+	for currency := range exceptions {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+
+	var b strings.Builder
+	b.WriteString("CASE " + column)
+	for _, currency := range currencies {
+		fmt.Fprintf(&b, " WHEN '%s' THEN %d", currency, exceptions[currency])
+	}
+	b.WriteString(" ELSE 2 END")
+	return b.String()
+}
@@ -0,0 +1,104 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/DeneesK/sub-service/internal/model"
+	"github.com/go-chi/chi/v5"
+)
+
+// CreateAPIKeyRequest names a new API key and lists its roles.
+type CreateAPIKeyRequest struct {
+	Name  string       `json:"name" example:"billing-cron"`
+	Roles []model.Role `json:"roles" swaggertype:"array,string" enums:"reader,writer,admin"`
+}
+
+// CreatedAPIKey is a new API key together with the key itself, which is
+// not shown again.
+type CreatedAPIKey struct {
+	model.APIKey
+	Key string `json:"key"`
+}
+
+// CreateAPIKey
+// @Summary Create API key
+// @Description Create an API key for a service account. The key is only returned in this response, pass it in the X-API-Key header. Roles: reader reads and aggregates every user's subscriptions, writer also changes them, admin also manages currency rates and API keys
+// @Tags api keys
+// @Accept json
+// @Produce json
+// @Param key body router.CreateAPIKeyRequest true "API key"
+// @Success 201 {object} router.CreatedAPIKey
+// @Failure 400 {object} router.Problem "Bad Request"
+// @Failure 401 {object} router.Problem "Unauthorized"
+// @Failure 403 {object} router.Problem "Forbidden"
+// @Failure 500 {object} router.Problem "Internal Server Error"
+// @Failure 503 {object} router.Problem "Request cancelled"
+// @Failure 504 {object} router.Problem "Request timed out"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/api-keys [post]
+func (h *SubscriptionHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyRequest
+	if err := decodeBody(r, &req); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	key := model.APIKey{Name: req.Name, Roles: req.Roles}
+	secret, err := h.keys.CreateAPIKey(r.Context(), &key)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreatedAPIKey{APIKey: key, Key: secret})
+}
+
+// ListAPIKeys
+// @Summary List API keys
+// @Description Get every API key, revoked ones included, oldest first. The keys themselves are not stored and cannot be listed
+// @Tags api keys
+// @Produce json
+// @Success 200 {array} model.APIKey
+// @Failure 401 {object} router.Problem "Unauthorized"
+// @Failure 403 {object} router.Problem "Forbidden"
+// @Failure 500 {object} router.Problem "Internal Server Error"
+// @Failure 503 {object} router.Problem "Request cancelled"
+// @Failure 504 {object} router.Problem "Request timed out"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/api-keys [get]
+func (h *SubscriptionHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.keys.ListAPIKeys(r.Context())
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(keys)
+}
+
+// RevokeAPIKey
+// @Summary Revoke API key
+// @Description Revoke an API key by its id, requests made with it are rejected from then on
+// @Tags api keys
+// @Param id path string true "API key ID"
+// @Success 204 {string} string "No Content"
+// @Failure 400 {object} router.Problem "Bad Request"
+// @Failure 401 {object} router.Problem "Unauthorized"
+// @Failure 403 {object} router.Problem "Forbidden"
+// @Failure 404 {object} router.Problem "Not Found"
+// @Failure 500 {object} router.Problem "Internal Server Error"
+// @Failure 503 {object} router.Problem "Request cancelled"
+// @Failure 504 {object} router.Problem "Request timed out"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/api-keys/{id} [delete]
+func (h *SubscriptionHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if err := h.keys.RevokeAPIKey(r.Context(), chi.URLParam(r, "id")); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,100 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/DeneesK/sub-service/internal/model"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// BatchResponse is the outcome of a batch request.
+type BatchResponse struct {
+	// Committed tells whether the successful operations were applied.
+	Committed bool              `json:"committed"`
+	Results   []BatchItemResult `json:"results"`
+}
+
+// BatchItemResult is the outcome of a single operation of a batch, Status
+// is the HTTP status the operation would have as a separate request.
+type BatchItemResult struct {
+	Index        int                 `json:"index"`
+	Action       model.BatchAction   `json:"action"`
+	Status       int                 `json:"status"`
+	ID           string              `json:"id,omitempty"`
+	Subscription *model.Subscription `json:"subscription,omitempty"`
+	Error        *Problem            `json:"error,omitempty"`
+}
+
+// BatchSubscriptions
+// @Summary Batch of subscription changes
+// @Description Create, update and delete subscriptions in one request. Every create is applied first, then the updates and deletes in the order they are listed. In atomic mode (default) either every operation is applied or none, in best_effort mode every operation that succeeds is applied. Responds with 200 when every operation succeeded and 207 with the per operation results otherwise
+// @Tags subscriptions
+// @Accept json
+// @Produce json
+// @Param batch body model.BatchRequest true "Operations to apply"
+// @Param X-Actor header string false "Who makes the change, recorded in the history (optional)"
+// @Param Idempotency-Key header string false "Retries with the same key get the response of the first request (optional)"
+// @Success 200 {object} router.BatchResponse
+// @Success 207 {object} router.BatchResponse "Some operations failed"
+// @Failure 400 {object} router.Problem "Bad Request"
+// @Failure 401 {object} router.Problem "Unauthorized"
+// @Failure 403 {object} router.Problem "Forbidden"
+// @Failure 409 {object} router.Problem "A request with the same Idempotency-Key is in progress"
+// @Failure 422 {object} router.Problem "Idempotency-Key was used with a different request"
+// @Failure 500 {object} router.Problem "Internal Server Error"
+// @Failure 503 {object} router.Problem "Request cancelled"
+// @Failure 504 {object} router.Problem "Request timed out"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /subs/batch [post]
+func (h *SubscriptionHandler) Batch(w http.ResponseWriter, r *http.Request) {
+	var req model.BatchRequest
+	if err := decodeBody(r, &req); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	res, err := h.svc.Batch(r.Context(), req.Mode, req.Operations)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	status := http.StatusOK
+	resp := BatchResponse{Committed: res.Committed, Results: make([]BatchItemResult, len(res.Items))}
+	for i, item := range res.Items {
+		result := BatchItemResult{
+			Index:        item.Index,
+			Action:       item.Action,
+			Status:       batchItemStatus(item.Action),
+			ID:           item.ID,
+			Subscription: item.Subscription,
+		}
+		if item.Err != nil {
+			p := newProblem(item.Err)
+			p.Instance = fmt.Sprintf("%s#/operations/%d", r.URL.Path, item.Index)
+			p.RequestID = middleware.GetReqID(r.Context())
+			result.Status = p.Status
+			result.Error = &p
+			status = http.StatusMultiStatus
+		}
+		resp.Results[i] = result
+	}
+
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// batchItemStatus is the status of a successful operation.
+func batchItemStatus(action model.BatchAction) int {
+	switch action {
+	case model.BatchCreate:
+		return http.StatusCreated
+	case model.BatchDelete:
+		return http.StatusNoContent
+	default:
+		return http.StatusOK
+	}
+}
@@ -0,0 +1,158 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/DeneesK/sub-service/internal/model"
+	"github.com/DeneesK/sub-service/internal/router/middlewares"
+	"github.com/DeneesK/sub-service/internal/service"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const problemContentType = "application/problem+json"
+
+// Problem types reported in the "type" member of problem details.
+const (
+	problemTypeValidation   = "/problems/validation-error"
+	problemTypeInvalidID    = "/problems/invalid-id"
+	problemTypeNotFound     = "/problems/not-found"
+	problemTypeConflict     = "/problems/conflict"
+	problemTypeMalformed    = "/problems/malformed-request"
+	problemTypePrecondition = "/problems/precondition-failed"
+	problemTypeRolledBack   = "/problems/batch-rolled-back"
+	problemTypeMediaType    = "/problems/unsupported-media-type"
+	problemTypeMissingRates = "/problems/missing-currency-rates"
+	problemTypeUnauthorized = "/problems/unauthorized"
+	problemTypeForbidden    = "/problems/forbidden"
+)
+
+// Problem is an RFC 7807 problem details response body.
+type Problem struct {
+	Type      string               `json:"type"`
+	Title     string               `json:"title"`
+	Status    int                  `json:"status"`
+	Detail    string               `json:"detail,omitempty"`
+	Instance  string               `json:"instance,omitempty"`
+	RequestID string               `json:"request_id,omitempty"`
+	Errors    []service.FieldError `json:"errors,omitempty"`
+	// MissingRates lists the currency rates an aggregate lacks.
+	MissingRates []model.MissingRate `json:"missing_rates,omitempty"`
+}
+
+// errMalformedRequest marks a request body or parameters that could not be
+// parsed at all, as opposed to values rejected by validation.
+var errMalformedRequest = errors.New("malformed request")
+
+// newProblem maps an error returned by SubService or by request parsing to
+// the problem reported to the client.
+func newProblem(err error) Problem {
+	var p Problem
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		p = Problem{Status: http.StatusGatewayTimeout, Detail: "request timed out"}
+	case errors.Is(err, context.Canceled):
+		p = Problem{Status: http.StatusServiceUnavailable, Detail: "request cancelled"}
+	case errors.Is(err, service.ErrNotFound), errors.Is(err, service.ErrAPIKeyNotFound):
+		p = Problem{Type: problemTypeNotFound, Status: http.StatusNotFound, Detail: err.Error()}
+	case errors.Is(err, service.ErrInvalidID), errors.Is(err, service.ErrInvalidAPIKeyID):
+		p = Problem{Type: problemTypeInvalidID, Status: http.StatusBadRequest, Detail: err.Error()}
+	case errors.Is(err, service.ErrValidation):
+		p = Problem{Type: problemTypeValidation, Status: http.StatusBadRequest, Detail: err.Error()}
+		var vErr *service.ValidationError
+		if errors.As(err, &vErr) {
+			p.Detail = "request has invalid fields"
+			p.Errors = vErr.Fields
+		}
+	case errors.Is(err, errMalformedRequest):
+		p = Problem{Type: problemTypeMalformed, Status: http.StatusBadRequest, Detail: err.Error()}
+	case errors.Is(err, service.ErrPreconditionFailed):
+		p = Problem{Type: problemTypePrecondition, Status: http.StatusPreconditionFailed, Detail: err.Error()}
+	case errors.Is(err, service.ErrRolledBack):
+		p = Problem{Type: problemTypeRolledBack, Status: http.StatusFailedDependency, Detail: err.Error()}
+	case errors.Is(err, errUnsupportedMediaType):
+		p = Problem{Type: problemTypeMediaType, Status: http.StatusUnsupportedMediaType, Detail: err.Error()}
+	case errors.Is(err, service.ErrMissingRates):
+		p = Problem{Type: problemTypeMissingRates, Status: http.StatusUnprocessableEntity, Detail: err.Error()}
+		var rErr *service.MissingRatesError
+		if errors.As(err, &rErr) {
+			p.Detail = "costs cannot be converted, currency rates are missing"
+			p.MissingRates = rErr.Rates
+		}
+	case errors.Is(err, middlewares.ErrUnauthorized):
+		p = Problem{Type: problemTypeUnauthorized, Status: http.StatusUnauthorized, Detail: err.Error()}
+	case errors.Is(err, middlewares.ErrForbidden):
+		p = Problem{Type: problemTypeForbidden, Status: http.StatusForbidden, Detail: err.Error()}
+	case errors.Is(err, service.ErrConflict):
+		p = Problem{Type: problemTypeConflict, Status: http.StatusConflict, Detail: err.Error()}
+	default:
+		p = Problem{Status: http.StatusInternalServerError, Detail: "internal error"}
+	}
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	p.Title = http.StatusText(p.Status)
+	return p
+}
+
+// writeProblem writes p as an application/problem+json response, filling in
+// the request specific members.
+func writeProblem(w http.ResponseWriter, r *http.Request, p Problem) {
+	p.Instance = r.URL.Path
+	p.RequestID = middleware.GetReqID(r.Context())
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// writeError responds to a failed request with the problem matching err.
+// A request aborted because the client went away or the deadline passed is
+// reported as such even if the service surfaced a different error.
+func (h *SubscriptionHandler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	if ctxErr := r.Context().Err(); ctxErr != nil {
+		err = ctxErr
+	}
+	p := newProblem(err)
+	if p.Status >= http.StatusInternalServerError {
+		h.log.Errorw("request failed", "method", r.Method, "uri", r.RequestURI, "error", err)
+	} else {
+		h.log.Debugw("request rejected", "method", r.Method, "uri", r.RequestURI, "error", err)
+	}
+	writeProblem(w, r, p)
+}
+
+// authFailed answers requests rejected by the auth middlewares, with the
+// WWW-Authenticate challenge of RFC 6750.
+func authFailed(w http.ResponseWriter, r *http.Request, err error) {
+	challenge := `Bearer`
+	switch {
+	case errors.Is(err, middlewares.ErrForbidden):
+		challenge = `Bearer error="insufficient_scope"`
+	case r.Header.Get("Authorization") != "":
+		challenge = `Bearer error="invalid_token"`
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	writeProblem(w, r, newProblem(err))
+}
+
+// notFound answers requests to unknown routes.
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(http.StatusNotFound),
+		Status: http.StatusNotFound,
+	})
+}
+
+// methodNotAllowed answers requests using a method the route does not serve.
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(http.StatusMethodNotAllowed),
+		Status: http.StatusMethodNotAllowed,
+	})
+}
@@ -0,0 +1,54 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/DeneesK/sub-service/internal/model"
+)
+
+// etag returns the entity tag of the subscription representation.
+func etag(sub *model.Subscription) string {
+	return `"` + strconv.FormatInt(sub.Version, 10) + `"`
+}
+
+// setETag advertises the version of sub to the client.
+func setETag(w http.ResponseWriter, sub *model.Subscription) {
+	w.Header().Set("ETag", etag(sub))
+}
+
+// ifMatchVersion returns the version required by the If-Match header, zero
+// when the header is absent or is "*". ok is false when the header lists
+// tags that can never match a subscription, such as weak or foreign ones.
+func ifMatchVersion(r *http.Request) (version int64, ok bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, true
+	}
+	if !strings.HasPrefix(header, `"`) || !strings.HasSuffix(header, `"`) || len(header) < 2 {
+		return 0, false
+	}
+	version, err := strconv.ParseInt(header[1:len(header)-1], 10, 64)
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+// noneMatch reports whether the If-None-Match header of r allows sending
+// sub, that is none of the listed tags matches it by weak comparison.
+func noneMatch(r *http.Request, sub *model.Subscription) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return true
+	}
+	current := etag(sub)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == current {
+			return false
+		}
+	}
+	return true
+}
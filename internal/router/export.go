@@ -0,0 +1,229 @@
+package router
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DeneesK/sub-service/internal/model"
+	"github.com/DeneesK/sub-service/internal/service"
+)
+
+// exportFormat is the representation of a list or aggregate response.
+type exportFormat string
+
+const (
+	formatJSON   exportFormat = "json"
+	formatCSV    exportFormat = "csv"
+	formatNDJSON exportFormat = "ndjson"
+	formatXLSX   exportFormat = "xlsx"
+)
+
+const contentTypeXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+var exportContentTypes = map[exportFormat]string{
+	formatJSON:   "application/json",
+	formatCSV:    contentTypeCSV,
+	formatNDJSON: contentTypeNDJSON,
+	formatXLSX:   contentTypeXLSX,
+}
+
+// parseFormat picks the response format from the format parameter or,
+// without it, from the first supported type in the Accept header. Types it
+// does not know fall back to JSON.
+func parseFormat(r *http.Request) (exportFormat, error) {
+	if v := r.URL.Query().Get("format"); v != "" {
+		format := exportFormat(strings.ToLower(v))
+		if _, ok := exportContentTypes[format]; !ok {
+			var vErr service.ValidationError
+			vErr.Add("format", "must be one of json, csv, ndjson, xlsx")
+			return formatJSON, vErr.Err()
+		}
+		return format, nil
+	}
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		for format, contentType := range exportContentTypes {
+			if mediaType == contentType {
+				return format, nil
+			}
+		}
+	}
+	return formatJSON, nil
+}
+
+// tableWriter writes rows of a table in one of the export formats.
+type tableWriter interface {
+	// Header starts the table with the names of its columns.
+	Header(columns []string) error
+	// Row writes a row of string, integer or nil values.
+	Row(values ...interface{}) error
+	// Close finishes the table.
+	Close() error
+}
+
+func newTableWriter(format exportFormat, w io.Writer, name string) tableWriter {
+	switch format {
+	case formatCSV:
+		return &csvTableWriter{w: csv.NewWriter(w)}
+	case formatXLSX:
+		return newXLSXWriter(w, name)
+	default:
+		return &ndjsonTableWriter{w: w}
+	}
+}
+
+// writeTable streams the table produced by rows to the client as an
+// attachment called name. Errors that occur after the response has started
+// abort the connection, so a truncated file is not taken for a complete one.
+func (h *SubscriptionHandler) writeTable(
+	w http.ResponseWriter, r *http.Request, format exportFormat, name string, columns []string,
+	rows func(row func(values ...interface{}) error) error,
+) {
+	out := &deferredResponseWriter{ResponseWriter: w, format: format, filename: name + "." + string(format)}
+	buf := bufio.NewWriterSize(out, 32*1024)
+	table := newTableWriter(format, buf, name)
+
+	err := table.Header(columns)
+	if err == nil {
+		err = rows(table.Row)
+	}
+	if err == nil {
+		err = table.Close()
+	}
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err == nil {
+		return
+	}
+	if !out.started {
+		h.writeError(w, r, err)
+		return
+	}
+	h.log.Errorw("export failed", "method", r.Method, "uri", r.RequestURI, "error", err)
+	panic(http.ErrAbortHandler)
+}
+
+// deferredResponseWriter sends the headers of an export with its first
+// bytes, so that a failure before that can still be reported as a problem.
+type deferredResponseWriter struct {
+	http.ResponseWriter
+	format   exportFormat
+	filename string
+	started  bool
+}
+
+func (d *deferredResponseWriter) Write(b []byte) (int, error) {
+	if !d.started {
+		d.started = true
+		d.Header().Set("Content-Type", exportContentTypes[d.format])
+		d.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": d.filename}))
+		d.WriteHeader(http.StatusOK)
+	}
+	return d.ResponseWriter.Write(b)
+}
+
+// subscriptionColumns are the columns of exported subscriptions.
+var subscriptionColumns = []string{
+	"id", "service_name", "price", "currency", "billing_period", "billing_interval", "user_id", "start_date", "end_date",
+	"version", "deleted_at",
+}
+
+// subscriptionRow returns the values of sub in the order of
+// subscriptionColumns.
+func subscriptionRow(sub *model.Subscription) []interface{} {
+	var endDate, deletedAt interface{}
+	if sub.EndDate != nil {
+		endDate = sub.EndDate.Format("01-2006")
+	}
+	if sub.DeletedAt != nil {
+		deletedAt = sub.DeletedAt.UTC().Format(time.RFC3339)
+	}
+	return []interface{}{
+		sub.ID, sub.ServiceName, sub.Price, sub.Currency, string(sub.BillingPeriod), sub.BillingInterval, sub.UserID,
+		sub.StartDate.Format("01-2006"), endDate, sub.Version, deletedAt,
+	}
+}
+
+type csvTableWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvTableWriter) Header(columns []string) error {
+	return c.w.Write(columns)
+}
+
+func (c *csvTableWriter) Row(values ...interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			record[i] = csvSafe(v)
+		default:
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvTableWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// csvSafe keeps spreadsheets from evaluating text cells as formulas.
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// ndjsonTableWriter writes every row as a JSON object with the columns as
+// keys, in column order.
+type ndjsonTableWriter struct {
+	w       io.Writer
+	columns []string
+	buf     []byte
+}
+
+func (n *ndjsonTableWriter) Header(columns []string) error {
+	n.columns = columns
+	return nil
+}
+
+func (n *ndjsonTableWriter) Row(values ...interface{}) error {
+	n.buf = append(n.buf[:0], '{')
+	for i, v := range values {
+		if i > 0 {
+			n.buf = append(n.buf, ',')
+		}
+		key, err := json.Marshal(n.columns[i])
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		n.buf = append(append(append(n.buf, key...), ':'), value...)
+	}
+	n.buf = append(n.buf, '}', '\n')
+	_, err := n.w.Write(n.buf)
+	return err
+}
+
+func (n *ndjsonTableWriter) Close() error {
+	return nil
+}
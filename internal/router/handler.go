@@ -2,69 +2,111 @@ package router
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/DeneesK/sub-service/internal/model"
+	"github.com/DeneesK/sub-service/internal/router/middlewares"
+	"github.com/DeneesK/sub-service/internal/service"
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 )
 
 type SubscriptionHandler struct {
-	svc SubService
-	log *zap.SugaredLogger
+	svc  SubService
+	keys APIKeyService
+	log  *zap.SugaredLogger
 }
 
-func NewSubscriptionHandler(svc SubService, log *zap.SugaredLogger) *SubscriptionHandler {
+func NewSubscriptionHandler(svc SubService, keys APIKeyService, log *zap.SugaredLogger) *SubscriptionHandler {
 	return &SubscriptionHandler{
-		svc: svc,
-		log: log,
+		svc:  svc,
+		keys: keys,
+		log:  log,
 	}
 }
 
 // CreateSubscription
 // @Summary Create subscription
-// @Description Create a new subscription record
+// @Description Create a new subscription record. Callers without the admin scope always create it for themselves
 // @Tags subscriptions
 // @Accept json
 // @Produce json
 // @Param subscription body model.Subscription true "Subscription object"
+// @Param X-Actor header string false "Who makes the change, recorded in the history (optional)"
+// @Param Idempotency-Key header string false "Retries with the same key get the response of the first request (optional)"
 // @Success 201 {object} model.Subscription
+// @Failure 400 {object} router.Problem "Bad Request"
+// @Failure 401 {object} router.Problem "Unauthorized"
+// @Failure 403 {object} router.Problem "Forbidden"
+// @Failure 409 {object} router.Problem "Conflict, or a request with the same Idempotency-Key is in progress"
+// @Failure 422 {object} router.Problem "Idempotency-Key was used with a different request"
+// @Failure 500 {object} router.Problem "Internal Server Error"
+// @Failure 503 {object} router.Problem "Request cancelled"
+// @Failure 504 {object} router.Problem "Request timed out"
+// @Security BearerAuth
+// @Security APIKeyAuth
 // @Router /subs [post]
 func (h *SubscriptionHandler) Create(w http.ResponseWriter, r *http.Request) {
 	var req model.Subscription
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := decodeBody(r, &req); err != nil {
+		h.writeError(w, r, err)
 		return
 	}
-	if err := h.svc.Create(&req); err != nil {
-		h.log.Error("create error", zap.Error(err))
-		http.Error(w, "internal error", http.StatusInternalServerError)
+	if err := h.svc.Create(r.Context(), &req); err != nil {
+		h.writeError(w, r, err)
 		return
 	}
+	setETag(w, &req)
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(req)
 }
 
-// CetSubscription
+// GetSubscription
 // @Summary Get subscription
 // @Description Get subscription by its id
 // @Tags subscriptions
 // @Produce json
-// @Param id query string true "Subscription id"
-// @Param subscription body model.Subscription true "Subscription object"
+// @Param id path string true "Subscription ID"
+// @Param include_deleted query bool false "Return the subscription even if it is deleted, admin only (optional)"
+// @Param If-None-Match header string false "ETag of a cached copy (optional)"
 // @Success 200 {object} model.Subscription
+// @Success 304 {string} string "Not Modified"
+// @Failure 400 {object} router.Problem "Bad Request"
+// @Failure 401 {object} router.Problem "Unauthorized"
+// @Failure 403 {object} router.Problem "include_deleted without the admin permission"
+// @Failure 404 {object} router.Problem "Not Found"
+// @Failure 500 {object} router.Problem "Internal Server Error"
+// @Failure 503 {object} router.Problem "Request cancelled"
+// @Failure 504 {object} router.Problem "Request timed out"
+// @Security BearerAuth
+// @Security APIKeyAuth
 // @Router /subs/{id} [get]
 func (h *SubscriptionHandler) Get(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	if id == "" {
-		http.Error(w, "must provide id", http.StatusBadRequest)
+	var vErr service.ValidationError
+	includeDeleted := boolParam(r.URL.Query(), "include_deleted", &vErr)
+	if err := vErr.Err(); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	if err := checkIncludeDeleted(r, includeDeleted); err != nil {
+		h.writeError(w, r, err)
 		return
 	}
-	sub, err := h.svc.Get(id)
+	sub, err := h.svc.Get(r.Context(), id, includeDeleted)
 	if err != nil {
-		h.log.Warnf("sub id %s not found", id)
-		http.Error(w, "Not found", http.StatusNotFound)
+		h.writeError(w, r, err)
+		return
+	}
+	setETag(w, sub)
+	if !noneMatch(r, sub) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -72,117 +114,503 @@ func (h *SubscriptionHandler) Get(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary Get list of subscriptions
-// @Description Get list of subscriptions by user_id. If user_id is empty returns all subs
+// @Description Get a page of subscriptions ordered by start date. Pass next_cursor of the response as cursor to get the next page.
+// @Description With format=csv, ndjson or xlsx, or Accept: text/csv or application/x-ndjson, every matching subscription is streamed as a file and limit is ignored.
 // @Tags subscriptions
 // @Produce json
-// @Param user_id query string false "User ID (optional)"
-// @Success 200 {array} model.Subscription
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Param user_id query string false "User ID (optional), callers without the admin scope always get their own"
+// @Param service_name query string false "Service name (optional)"
+// @Param active_at query string false "Only subscriptions active in the month (optional)" example(07-2025)
+// @Param price_min query int false "Minimal price (optional)"
+// @Param price_max query int false "Maximal price (optional)"
+// @Param sort query string false "Sort order (optional)" Enums(-start_date, start_date) default(-start_date)
+// @Param limit query int false "Page size (optional)" minimum(1) maximum(500) default(50)
+// @Param cursor query string false "Cursor of the page (optional)"
+// @Param include_deleted query bool false "List deleted subscriptions too, admin only (optional)"
+// @Param format query string false "Response format, overrides the Accept header (optional)" Enums(json, csv, ndjson, xlsx)
+// @Success 200 {object} model.SubscriptionPage
+// @Failure 400 {object} router.Problem "Bad Request"
+// @Failure 401 {object} router.Problem "Unauthorized"
+// @Failure 403 {object} router.Problem "include_deleted without the admin permission"
+// @Failure 500 {object} router.Problem "Internal Server Error"
+// @Failure 503 {object} router.Problem "Request cancelled"
+// @Failure 504 {object} router.Problem "Request timed out"
+// @Security BearerAuth
+// @Security APIKeyAuth
 // @Router /subs [get]
 func (h *SubscriptionHandler) List(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("user_id")
-	subs, err := h.svc.List(userID)
+	f, err := parseListFilter(r)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	format, err := parseFormat(r)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	if format != formatJSON {
+		h.writeTable(w, r, format, "subscriptions", subscriptionColumns, func(row func(values ...interface{}) error) error {
+			return h.svc.Export(r.Context(), f, func(sub *model.Subscription) error {
+				return row(subscriptionRow(sub)...)
+			})
+		})
+		return
+	}
+
+	page, err := h.svc.List(r.Context(), f)
 	if err != nil {
-		h.log.Error("list error", zap.Error(err))
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		h.writeError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(subs)
+	json.NewEncoder(w).Encode(page)
 }
 
 // UpdateSubscription
 // @Summary Update subscription
-// @Description Update subscription by its id
+// @Description Update subscription by its id with JSON Merge Patch semantics: absent fields are kept, "end_date": null removes the end date. Responds with the updated subscription
 // @Tags subscriptions
 // @Accept json
 // @Produce json
 // @Param id path string true "Subscription ID"
 // @Param subscription body model.UpdateSubscription true "UpdateSubscription object"
+// @Param X-Actor header string false "Who makes the change, recorded in the history (optional)"
+// @Param If-Match header string false "Apply only if the subscription still has this ETag (optional)"
 // @Success 200 {object} model.Subscription
-// @Failure 400 {string} string "Bad Request"
-// @Failure 404 {string} string "Not Found"
+// @Failure 400 {object} router.Problem "Bad Request"
+// @Failure 401 {object} router.Problem "Unauthorized"
+// @Failure 403 {object} router.Problem "Forbidden"
+// @Failure 404 {object} router.Problem "Not Found"
+// @Failure 409 {object} router.Problem "Modified concurrently"
+// @Failure 412 {object} router.Problem "Precondition Failed"
+// @Failure 500 {object} router.Problem "Internal Server Error"
+// @Failure 503 {object} router.Problem "Request cancelled"
+// @Failure 504 {object} router.Problem "Request timed out"
+// @Security BearerAuth
+// @Security APIKeyAuth
 // @Router /subs/{id} [patch]
 func (h *SubscriptionHandler) Update(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	if id == "" {
-		http.Error(w, "id required", http.StatusBadRequest)
+
+	version, ok := ifMatchVersion(r)
+	if !ok {
+		h.writeError(w, r, fmt.Errorf("%w: If-Match does not match any version", service.ErrPreconditionFailed))
 		return
 	}
 
 	var req model.UpdateSubscription
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := decodeBody(r, &req); err != nil {
+		h.writeError(w, r, err)
 		return
 	}
 
-	if err := h.svc.Update(id, &req); err != nil {
-		h.log.Error("update error", zap.Error(err))
-		http.Error(w, "not found", http.StatusNotFound)
+	sub, err := h.svc.Update(r.Context(), id, version, &req)
+	if err != nil {
+		h.writeError(w, r, err)
 		return
 	}
 
+	setETag(w, sub)
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(req)
+	json.NewEncoder(w).Encode(sub)
 }
 
 // DeleteSubscription
 // @Summary Delete subscription
-// @Description Delete subscription by its id
+// @Description Delete subscription by its id. The subscription can be restored until deleted subscriptions are purged
 // @Tags subscriptions
-// @Produce plain
+// @Produce json
 // @Param id path string true "Subscription ID"
+// @Param X-Actor header string false "Who makes the change, recorded in the history (optional)"
+// @Param If-Match header string false "Apply only if the subscription still has this ETag (optional)"
 // @Success 204 {string} string "No Content"
-// @Failure 404 {string} string "Not Found"
+// @Failure 400 {object} router.Problem "Bad Request"
+// @Failure 401 {object} router.Problem "Unauthorized"
+// @Failure 403 {object} router.Problem "Forbidden"
+// @Failure 404 {object} router.Problem "Not Found"
+// @Failure 409 {object} router.Problem "Modified concurrently"
+// @Failure 412 {object} router.Problem "Precondition Failed"
+// @Failure 500 {object} router.Problem "Internal Server Error"
+// @Failure 503 {object} router.Problem "Request cancelled"
+// @Failure 504 {object} router.Problem "Request timed out"
+// @Security BearerAuth
+// @Security APIKeyAuth
 // @Router /subs/{id} [delete]
 func (h *SubscriptionHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	if id == "" {
-		http.Error(w, "id required", http.StatusBadRequest)
+	version, ok := ifMatchVersion(r)
+	if !ok {
+		h.writeError(w, r, fmt.Errorf("%w: If-Match does not match any version", service.ErrPreconditionFailed))
 		return
 	}
 
-	if err := h.svc.Delete(id); err != nil {
-		h.log.Error("delete error", zap.Error(err))
-		http.Error(w, "not found", http.StatusNotFound)
+	if err := h.svc.Delete(r.Context(), id, version); err != nil {
+		h.writeError(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// RestoreSubscription
+// @Summary Restore subscription
+// @Description Restore a deleted subscription by its id. Restoring a subscription that is not deleted is a conflict
+// @Tags subscriptions
+// @Produce json
+// @Param id path string true "Subscription ID"
+// @Param X-Actor header string false "Who makes the change, recorded in the history (optional)"
+// @Success 200 {object} model.Subscription
+// @Failure 400 {object} router.Problem "Bad Request"
+// @Failure 401 {object} router.Problem "Unauthorized"
+// @Failure 403 {object} router.Problem "Forbidden"
+// @Failure 404 {object} router.Problem "Not Found"
+// @Failure 409 {object} router.Problem "Subscription is not deleted"
+// @Failure 500 {object} router.Problem "Internal Server Error"
+// @Failure 503 {object} router.Problem "Request cancelled"
+// @Failure 504 {object} router.Problem "Request timed out"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /subs/{id}/restore [post]
+func (h *SubscriptionHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	sub, err := h.svc.Restore(r.Context(), id)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	setETag(w, sub)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(sub)
+}
+
+// SubscriptionHistory
+// @Summary Subscription history
+// @Description Get the audit trail of a subscription, oldest change first
+// @Tags subscriptions
+// @Produce json
+// @Param id path string true "Subscription ID"
+// @Success 200 {array} model.SubscriptionEvent
+// @Failure 400 {object} router.Problem "Bad Request"
+// @Failure 401 {object} router.Problem "Unauthorized"
+// @Failure 404 {object} router.Problem "Not Found"
+// @Failure 500 {object} router.Problem "Internal Server Error"
+// @Failure 503 {object} router.Problem "Request cancelled"
+// @Failure 504 {object} router.Problem "Request timed out"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /subs/{id}/history [get]
+func (h *SubscriptionHandler) History(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	events, err := h.svc.History(r.Context(), id)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(events)
+}
+
 // AggregateSubscription
 // @Summary Aggregate subscriptions cost
-// @Description Sum prices between dates, optional filters user_id & service_name
+// @Description Sum prices between dates, optional filters user_id & service_name.
+// @Description Prices are normalized to a month by their billing cycle, a 1200 per year plan costs 100.
+// @Description Totals are in minor units of the currency, every cost is converted at the rate of its month. If rates are missing, they are listed in a 422 response.
+// @Description By default every subscription is counted once for each month it is active within the period (mode=prorated),
+// @Description mode=starts sums monthly costs of subscriptions started within the period.
+// @Description The result can be downloaded as a table with format=csv, ndjson or xlsx.
 // @Tags subscriptions
 // @Produce json
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
 // @Param from query string true "Start month-year" example(01-2025)
 // @Param to query string true "End month-year"   example(07-2025)
-// @Param user_id query string false "User ID (optional)"
+// @Param user_id query string false "User ID (optional), callers without the admin scope always get their own"
 // @Param service_name query string false "Service name(optional)"
+// @Param mode query string false "Aggregation mode (optional)" Enums(prorated, starts)
+// @Param currency query string false "Currency of the totals (optional)" default(RUB)
+// @Param include_deleted query bool false "Count deleted subscriptions too, admin only (optional)"
+// @Param group_by query []string false "Group by dimensions (optional), returns a list of {key, total, count}" collectionFormat(multi) Enums(service_name, user_id)
+// @Param format query string false "Response format, overrides the Accept header (optional)" Enums(json, csv, ndjson, xlsx)
 // @Success 200 {object} map[string]int
+// @Failure 400 {object} router.Problem "Bad Request"
+// @Failure 401 {object} router.Problem "Unauthorized"
+// @Failure 403 {object} router.Problem "include_deleted without the admin permission"
+// @Failure 422 {object} router.Problem "Missing currency rates"
+// @Failure 500 {object} router.Problem "Internal Server Error"
+// @Failure 503 {object} router.Problem "Request cancelled"
+// @Failure 504 {object} router.Problem "Request timed out"
+// @Security BearerAuth
+// @Security APIKeyAuth
 // @Router /subs/aggregate [get]
 func (h *SubscriptionHandler) Aggregate(w http.ResponseWriter, r *http.Request) {
-	fromStr := r.URL.Query().Get("from")
-	toStr := r.URL.Query().Get("to")
-	userID := r.URL.Query().Get("user_id")
-	serviceName := r.URL.Query().Get("service_name")
-
-	from, err := time.Parse("01-2006", fromStr)
+	f, err := parseAggregateFilter(r)
 	if err != nil {
-		http.Error(w, "invalid from", http.StatusBadRequest)
+		h.writeError(w, r, err)
 		return
 	}
-	to, err := time.Parse("01-2006", toStr)
+	format, err := parseFormat(r)
 	if err != nil {
-		http.Error(w, "invalid to", http.StatusBadRequest)
+		h.writeError(w, r, err)
 		return
 	}
 
-	sum, err := h.svc.Aggregate(from, to.AddDate(0, 1, -1), userID, serviceName)
+	if len(f.GroupBy) > 0 {
+		groups, err := h.svc.AggregateGroups(r.Context(), f)
+		if err != nil {
+			h.writeError(w, r, err)
+			return
+		}
+		if format != formatJSON {
+			columns := make([]string, 0, len(f.GroupBy)+2)
+			for _, dim := range f.GroupBy {
+				columns = append(columns, string(dim))
+			}
+			columns = append(columns, "total", "count")
+			h.writeTable(w, r, format, "aggregate", columns, func(row func(values ...interface{}) error) error {
+				for _, g := range groups {
+					values := make([]interface{}, 0, len(columns))
+					for _, dim := range f.GroupBy {
+						values = append(values, g.Key[dim])
+					}
+					if err := row(append(values, g.Total, g.Count)...); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(groups)
+		return
+	}
+
+	sum, err := h.svc.Aggregate(r.Context(), f)
 	if err != nil {
-		h.log.Error("aggregate error", zap.Error(err))
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		h.writeError(w, r, err)
+		return
+	}
+	if format != formatJSON {
+		h.writeTable(w, r, format, "aggregate", []string{"total"}, func(row func(values ...interface{}) error) error {
+			return row(sum)
+		})
 		return
 	}
 	json.NewEncoder(w).Encode(map[string]int{"total": sum})
 }
+
+// AggregateMonthlySubscription
+// @Summary Monthly subscriptions cost
+// @Description Spend and number of active subscriptions for every month between dates, optional filters user_id & service_name.
+// @Description Totals are in minor units of the currency, converted at the rate of each month.
+// @Description The result can be downloaded as a table with format=csv, ndjson or xlsx.
+// @Tags subscriptions
+// @Produce json
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Param from query string true "Start month-year" example(01-2025)
+// @Param to query string true "End month-year"   example(07-2025)
+// @Param user_id query string false "User ID (optional), callers without the admin scope always get their own"
+// @Param service_name query string false "Service name(optional)"
+// @Param currency query string false "Currency of the totals (optional)" default(RUB)
+// @Param include_deleted query bool false "Count deleted subscriptions too, admin only (optional)"
+// @Param format query string false "Response format, overrides the Accept header (optional)" Enums(json, csv, ndjson, xlsx)
+// @Success 200 {array} model.MonthlyAggregate
+// @Failure 400 {object} router.Problem "Bad Request"
+// @Failure 401 {object} router.Problem "Unauthorized"
+// @Failure 403 {object} router.Problem "include_deleted without the admin permission"
+// @Failure 422 {object} router.Problem "Missing currency rates"
+// @Failure 500 {object} router.Problem "Internal Server Error"
+// @Failure 503 {object} router.Problem "Request cancelled"
+// @Failure 504 {object} router.Problem "Request timed out"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /subs/aggregate/monthly [get]
+func (h *SubscriptionHandler) AggregateMonthly(w http.ResponseWriter, r *http.Request) {
+	f, err := parseAggregateFilter(r)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	format, err := parseFormat(r)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	months, err := h.svc.AggregateMonthly(r.Context(), f)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	if format != formatJSON {
+		columns := []string{"month", "total", "count"}
+		h.writeTable(w, r, format, "monthly", columns, func(row func(values ...interface{}) error) error {
+			for _, m := range months {
+				if err := row(m.Month.Format("01-2006"), m.Total, m.Count); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(months)
+}
+
+// decodeBody decodes the JSON request body into v.
+func decodeBody(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", errMalformedRequest, err)
+	}
+	return nil
+}
+
+func parseListFilter(r *http.Request) (model.ListFilter, error) {
+	q := r.URL.Query()
+	var vErr service.ValidationError
+	f := model.ListFilter{
+		UserID:      q.Get("user_id"),
+		ServiceName: q.Get("service_name"),
+		Sort:        model.ListSort(q.Get("sort")),
+		Cursor:      q.Get("cursor"),
+	}
+	f.IncludeDeleted = boolParam(q, "include_deleted", &vErr)
+
+	if v := q.Get("active_at"); v != "" {
+		activeAt, err := time.Parse("01-2006", v)
+		if err != nil {
+			vErr.Add("active_at", "must be a month in MM-YYYY format")
+		}
+		f.ActiveAt = &activeAt
+	}
+	intParam := func(name string) *int {
+		v := q.Get(name)
+		if v == "" {
+			return nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			vErr.Add(name, "must be an integer")
+			return nil
+		}
+		return &n
+	}
+	f.PriceMin = intParam("price_min")
+	f.PriceMax = intParam("price_max")
+	if limit := intParam("limit"); limit != nil {
+		if *limit == 0 {
+			vErr.Add("limit", "must be between 1 and 500")
+		}
+		f.Limit = *limit
+	}
+
+	if err := vErr.Err(); err != nil {
+		return f, err
+	}
+	return f, checkIncludeDeleted(r, f.IncludeDeleted)
+}
+
+func parseAggregateFilter(r *http.Request) (model.AggregateFilter, error) {
+	q := r.URL.Query()
+	var vErr service.ValidationError
+
+	from, err := time.Parse("01-2006", q.Get("from"))
+	if err != nil {
+		vErr.Add("from", "must be a month in MM-YYYY format")
+	}
+	to, err := time.Parse("01-2006", q.Get("to"))
+	if err != nil {
+		vErr.Add("to", "must be a month in MM-YYYY format")
+	} else if to.Before(from) {
+		vErr.Add("to", "must not be before from")
+	}
+
+	mode := model.AggregateMode(q.Get("mode"))
+	switch mode {
+	case "":
+		mode = model.AggregateProrated
+	case model.AggregateProrated, model.AggregateStarts:
+	default:
+		vErr.Add("mode", "must be one of prorated, starts")
+	}
+
+	var groupBy []model.AggregateDimension
+	for _, v := range q["group_by"] {
+		for _, d := range strings.Split(v, ",") {
+			dim := model.AggregateDimension(strings.TrimSpace(d))
+			switch dim {
+			case model.DimensionServiceName, model.DimensionUserID:
+			default:
+				vErr.Add("group_by", "must be one of service_name, user_id")
+				continue
+			}
+			if !slices.Contains(groupBy, dim) {
+				groupBy = append(groupBy, dim)
+			}
+		}
+	}
+
+	currency := model.DefaultCurrency
+	if v := q.Get("currency"); v != "" {
+		currency = strings.ToUpper(v)
+		if !model.IsCurrencyCode(currency) {
+			vErr.Add("currency", "must be an ISO 4217 currency code")
+		}
+	}
+
+	includeDeleted := boolParam(q, "include_deleted", &vErr)
+
+	if err := vErr.Err(); err != nil {
+		return model.AggregateFilter{}, err
+	}
+	if err := checkIncludeDeleted(r, includeDeleted); err != nil {
+		return model.AggregateFilter{}, err
+	}
+	return model.AggregateFilter{
+		From:           from,
+		To:             to.AddDate(0, 1, -1),
+		UserID:         q.Get("user_id"),
+		ServiceName:    q.Get("service_name"),
+		Mode:           mode,
+		GroupBy:        groupBy,
+		Currency:       currency,
+		IncludeDeleted: includeDeleted,
+	}, nil
+}
+
+// checkIncludeDeleted rejects include_deleted from callers without the admin
+// permission, deleted subscriptions are only disclosed to admins.
+func checkIncludeDeleted(r *http.Request, includeDeleted bool) error {
+	if !includeDeleted {
+		return nil
+	}
+	if p, ok := middlewares.PrincipalFromContext(r.Context()); !ok || !p.Can(model.PermissionAdmin) {
+		return fmt.Errorf("%w: include_deleted requires the %s permission", middlewares.ErrForbidden, model.PermissionAdmin)
+	}
+	return nil
+}
+
+// boolParam parses an optional boolean query parameter, reporting malformed
+// values to vErr.
+func boolParam(q url.Values, name string, vErr *service.ValidationError) bool {
+	v := q.Get(name)
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		vErr.Add(name, "must be a boolean")
+	}
+	return b
+}
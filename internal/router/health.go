@@ -0,0 +1,54 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// readinessTimeout bounds the dependency checks of a readiness probe.
+const readinessTimeout = 2 * time.Second
+
+// ReadinessCheck reports why the instance cannot serve requests, it returns
+// nil when it can.
+type ReadinessCheck func(ctx context.Context) error
+
+// HealthStatus is the response body of the health endpoints.
+type HealthStatus struct {
+	Status string `json:"status"`
+	// Error tells why the instance is not ready.
+	Error string `json:"error,omitempty"`
+}
+
+// healthz is the liveness probe, it only tells that the process serves
+// HTTP and checks no dependency.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, HealthStatus{Status: "ok"})
+}
+
+// readyz returns the readiness probe, it answers 503 while check fails. A
+// nil check always reports ready.
+func readyz(check ReadinessCheck, log *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if check != nil {
+			ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+			defer cancel()
+			if err := check(ctx); err != nil {
+				log.Warnf("instance is not ready: %v", err)
+				writeHealth(w, http.StatusServiceUnavailable, HealthStatus{Status: "unavailable", Error: err.Error()})
+				return
+			}
+		}
+		writeHealth(w, http.StatusOK, HealthStatus{Status: "ok"})
+	}
+}
+
+func writeHealth(w http.ResponseWriter, status int, body HealthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,202 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/DeneesK/sub-service/internal/model"
+	"github.com/DeneesK/sub-service/internal/router/middlewares"
+	"go.uber.org/zap"
+)
+
+const (
+	// IdempotencyKeyHeader carries the client chosen key of a retryable request.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader marks responses replayed from the store.
+	idempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLen = 255
+
+	problemTypeIdempotencyKeyReused     = "/problems/idempotency-key-reused"
+	problemTypeIdempotencyKeyInProgress = "/problems/idempotency-key-in-progress"
+)
+
+// IdempotencyStore keeps the responses of requests made with an
+// Idempotency-Key header. Keys are scoped to the subject of the caller.
+type IdempotencyStore interface {
+	// Reserve claims rec.Key of rec.Subject for the request, or returns the
+	// record that already holds the key.
+	Reserve(ctx context.Context, rec *model.IdempotencyRecord) (*model.IdempotencyRecord, error)
+	// Complete stores the response of the request holding rec.Key.
+	Complete(ctx context.Context, rec *model.IdempotencyRecord) error
+	// Release frees the key of a request that failed and may be retried.
+	Release(ctx context.Context, subject, key string) error
+}
+
+// IdempotencyConfig configures Idempotency-Key support, it is disabled when
+// Store is nil.
+type IdempotencyConfig struct {
+	Store IdempotencyStore
+	// TTL is how long a key and its response are kept.
+	TTL time.Duration
+}
+
+// newIdempotencyMiddleware answers retries of a request carrying the same
+// Idempotency-Key with the response of the first one. Reusing a key for a
+// different request is rejected with 422, retrying while the first request
+// is still processed with 409. Server errors, panics and requests left
+// without a response are not stored, so the request can be retried with the
+// same key.
+func newIdempotencyMiddleware(conf IdempotencyConfig, log *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if conf.Store == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLen {
+				writeProblem(w, r, newProblem(fmt.Errorf(
+					"%w: %s must be at most %d characters long", errMalformedRequest, IdempotencyKeyHeader, maxIdempotencyKeyLen)))
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeProblem(w, r, newProblem(fmt.Errorf("%w: %v", errMalformedRequest, err)))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			rec := &model.IdempotencyRecord{
+				Subject:     subject(r),
+				Key:         key,
+				RequestHash: requestHash(r, body),
+				ExpiresAt:   time.Now().Add(conf.TTL),
+			}
+			existing, err := conf.Store.Reserve(r.Context(), rec)
+			if err != nil {
+				log.Errorw("failed to reserve idempotency key", "key", key, "error", err)
+				writeProblem(w, r, newProblem(err))
+				return
+			}
+			if existing != nil {
+				replay(w, r, existing, rec.RequestHash)
+				return
+			}
+
+			rw := &recordingResponseWriter{ResponseWriter: w}
+			// The outcome is settled in a defer so that a panicking handler
+			// does not keep the key reserved until it expires. It is stored
+			// even if the client has gone away, so that its retry gets it.
+			defer func() {
+				ctx := context.WithoutCancel(r.Context())
+				if p := recover(); p != nil || !rw.wroteHeader || rw.status >= http.StatusInternalServerError {
+					if err := conf.Store.Release(ctx, rec.Subject, key); err != nil {
+						log.Errorw("failed to release idempotency key", "key", key, "error", err)
+					}
+					if p != nil {
+						panic(p)
+					}
+					return
+				}
+				rec.Status = rw.status
+				rec.Body = rw.body.Bytes()
+				var err error
+				if rec.Header, err = json.Marshal(rw.header); err == nil {
+					err = conf.Store.Complete(ctx, rec)
+				}
+				if err != nil {
+					log.Errorw("failed to store idempotent response", "key", key, "error", err)
+				}
+			}()
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// replay answers r, whose hash is given, with the stored outcome of the
+// request that first used its key.
+func replay(w http.ResponseWriter, r *http.Request, rec *model.IdempotencyRecord, hash string) {
+	switch {
+	case rec.RequestHash != hash:
+		writeProblem(w, r, Problem{
+			Type:   problemTypeIdempotencyKeyReused,
+			Title:  http.StatusText(http.StatusUnprocessableEntity),
+			Status: http.StatusUnprocessableEntity,
+			Detail: IdempotencyKeyHeader + " was already used with a different request",
+		})
+		return
+	case rec.Status == 0:
+		writeProblem(w, r, Problem{
+			Type:   problemTypeIdempotencyKeyInProgress,
+			Title:  http.StatusText(http.StatusConflict),
+			Status: http.StatusConflict,
+			Detail: "a request with this " + IdempotencyKeyHeader + " is still being processed",
+		})
+		return
+	}
+
+	var header http.Header
+	if len(rec.Header) > 0 {
+		json.Unmarshal(rec.Header, &header)
+	}
+	for name, values := range header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(idempotentReplayedHeader, "true")
+	w.WriteHeader(rec.Status)
+	w.Write(rec.Body)
+}
+
+// subject returns the caller the keys of r are scoped to.
+func subject(r *http.Request) string {
+	p, _ := middlewares.PrincipalFromContext(r.Context())
+	return p.Subject
+}
+
+// requestHash identifies a request by its method, path and body.
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.Path)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recordingResponseWriter passes the response through while keeping a copy
+// of it.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	header      http.Header
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (rw *recordingResponseWriter) WriteHeader(status int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
+	rw.status = status
+	rw.header = rw.ResponseWriter.Header().Clone()
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingResponseWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
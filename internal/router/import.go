@@ -0,0 +1,252 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/DeneesK/sub-service/internal/model"
+	"github.com/DeneesK/sub-service/internal/service"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const (
+	contentTypeCSV    = "text/csv"
+	contentTypeNDJSON = "application/x-ndjson"
+
+	// maxNDJSONLineSize bounds a single line of a JSON Lines import.
+	maxNDJSONLineSize = 1 << 20
+)
+
+// errUnsupportedMediaType marks a request body in a format the endpoint
+// does not accept.
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// ImportResponse summarizes an import.
+type ImportResponse struct {
+	DryRun   bool `json:"dry_run"`
+	Total    int  `json:"total"`
+	Imported int  `json:"imported"`
+	Failed   int  `json:"failed"`
+	// Errors lists the rejected lines, at most 1000 of them.
+	Errors []ImportLineError `json:"errors"`
+}
+
+// ImportLineError is the reason a line of the file was rejected.
+type ImportLineError struct {
+	Line  int     `json:"line"`
+	Error Problem `json:"error"`
+}
+
+// ImportSubscriptions
+// @Summary Import subscriptions
+// @Description Create subscriptions from a CSV file with a header row (service_name, price, user_id, start_date and optionally end_date, currency, billing_period, billing_interval) or from JSON Lines with one subscription per line. Prices are in minor units. Dates use the MM-YYYY layout. Invalid lines are reported and skipped, the other lines are imported
+// @Tags subscriptions
+// @Accept text/csv
+// @Accept application/x-ndjson
+// @Produce json
+// @Param file body string true "CSV or JSON Lines file"
+// @Param dry_run query bool false "Only validate the file (optional)"
+// @Param X-Actor header string false "Who makes the change, recorded in the history (optional)"
+// @Success 200 {object} router.ImportResponse
+// @Failure 400 {object} router.Problem "Bad Request"
+// @Failure 401 {object} router.Problem "Unauthorized"
+// @Failure 403 {object} router.Problem "Forbidden"
+// @Failure 415 {object} router.Problem "Unsupported Media Type"
+// @Failure 500 {object} router.Problem "Internal Server Error"
+// @Failure 503 {object} router.Problem "Request cancelled"
+// @Failure 504 {object} router.Problem "Request timed out"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /subs/import [post]
+func (h *SubscriptionHandler) Import(w http.ResponseWriter, r *http.Request) {
+	var vErr service.ValidationError
+	dryRun := boolParam(r.URL.Query(), "dry_run", &vErr)
+	if err := vErr.Err(); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	src, err := newSubscriptionReader(r)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	res, err := h.svc.Import(r.Context(), src, dryRun)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	resp := ImportResponse{
+		DryRun:   res.DryRun,
+		Total:    res.Total,
+		Imported: res.Imported,
+		Failed:   res.Failed,
+		Errors:   make([]ImportLineError, len(res.Errors)),
+	}
+	for i, lineErr := range res.Errors {
+		p := newProblem(lineErr.Err)
+		p.Instance = fmt.Sprintf("%s#line=%d", r.URL.Path, lineErr.Line)
+		p.RequestID = middleware.GetReqID(r.Context())
+		resp.Errors[i] = ImportLineError{Line: lineErr.Line, Error: p}
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// newSubscriptionReader reads the body of r in the format of its
+// Content-Type.
+func newSubscriptionReader(r *http.Request) (model.SubscriptionReader, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: Content-Type must be %s or %s", errUnsupportedMediaType, contentTypeCSV, contentTypeNDJSON)
+	}
+	switch mediaType {
+	case contentTypeCSV:
+		return newCSVReader(r.Body)
+	case contentTypeNDJSON:
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxNDJSONLineSize)
+		return &ndjsonReader{scanner: scanner}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q, expected %s or %s", errUnsupportedMediaType, mediaType, contentTypeCSV, contentTypeNDJSON)
+	}
+}
+
+// csvColumns are the columns of a CSV import, the ones after start_date may
+// be omitted.
+var csvColumns = []string{
+	"service_name", "price", "user_id", "start_date", "end_date", "currency", "billing_period", "billing_interval",
+}
+
+// csvReader reads subscriptions from CSV with a header row naming the
+// columns, in any order.
+type csvReader struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+func newCSVReader(body io.Reader) (*csvReader, error) {
+	r := csv.NewReader(body)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: CSV header row is missing", errMalformedRequest)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errMalformedRequest, err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if i == 0 {
+			// Spreadsheets like to start the file with a byte order mark.
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		columns[name] = i
+	}
+	var missing []string
+	for _, name := range csvColumns[:4] {
+		if _, ok := columns[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: CSV header lacks columns %s", errMalformedRequest, strings.Join(missing, ", "))
+	}
+	return &csvReader{r: r, columns: columns}, nil
+}
+
+func (c *csvReader) Read() (model.ImportRow, error) {
+	record, err := c.r.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			// The reader resumes at the next record.
+			return model.ImportRow{Line: parseErr.StartLine, Err: fmt.Errorf("%w: %v", errMalformedRequest, parseErr.Err)}, nil
+		}
+		return model.ImportRow{}, err
+	}
+	line, _ := c.r.FieldPos(0)
+	row := model.ImportRow{Line: line}
+
+	field := func(name string) string {
+		if i, ok := c.columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	var vErr service.ValidationError
+	sub := &model.Subscription{
+		ServiceName:   field("service_name"),
+		UserID:        field("user_id"),
+		Currency:      field("currency"),
+		BillingPeriod: model.BillingPeriod(field("billing_period")),
+	}
+	if price := field("price"); price != "" {
+		if sub.Price, err = strconv.Atoi(price); err != nil {
+			vErr.Add("price", "must be an integer")
+		}
+	} else {
+		vErr.Add("price", "is required")
+	}
+	if startDate := field("start_date"); startDate != "" {
+		if sub.StartDate, err = model.ParseMonthYear(startDate); err != nil {
+			vErr.Add("start_date", "must be in MM-YYYY format")
+		}
+	}
+	if interval := field("billing_interval"); interval != "" {
+		if sub.BillingInterval, err = strconv.Atoi(interval); err != nil {
+			vErr.Add("billing_interval", "must be an integer")
+		}
+	}
+	if endDate := field("end_date"); endDate != "" {
+		date, err := model.ParseMonthYear(endDate)
+		if err != nil {
+			vErr.Add("end_date", "must be in MM-YYYY format")
+		}
+		sub.EndDate = &date
+	}
+	// The service validates the parsed fields and adds their errors.
+	row.Subscription, row.Err = sub, vErr.Err()
+	return row, nil
+}
+
+// ndjsonReader reads subscriptions from JSON Lines, skipping blank lines.
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (n *ndjsonReader) Read() (model.ImportRow, error) {
+	for n.scanner.Scan() {
+		n.line++
+		data := bytes.TrimSpace(n.scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		row := model.ImportRow{Line: n.line}
+		var sub model.Subscription
+		if err := json.Unmarshal(data, &sub); err != nil {
+			row.Err = fmt.Errorf("%w: %v", errMalformedRequest, err)
+		} else {
+			row.Subscription = &sub
+		}
+		return row, nil
+	}
+	if err := n.scanner.Err(); err != nil {
+		return model.ImportRow{}, fmt.Errorf("%w: line %d: %v", errMalformedRequest, n.line+1, err)
+	}
+	return model.ImportRow{}, io.EOF
+}
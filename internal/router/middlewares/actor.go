@@ -0,0 +1,26 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/DeneesK/sub-service/internal/service"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// ActorHeader names who makes the request, it is recorded in the audit trail.
+const ActorHeader = "X-Actor"
+
+// NewActorMiddleware stores the actor of the request and its request id in
+// the request context for the audit trail. It must run after
+// middleware.RequestID.
+func NewActorMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := service.WithActor(r.Context(), service.Actor{
+				Name:      r.Header.Get(ActorHeader),
+				RequestID: middleware.GetReqID(r.Context()),
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/DeneesK/sub-service/internal/model"
+	"github.com/DeneesK/sub-service/internal/service"
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultAdminScope is the token scope granting the admin role.
+	DefaultAdminScope = "admin"
+	// APIKeyHeader carries the API key of a service account.
+	APIKeyHeader = "X-API-Key"
+)
+
+var (
+	// ErrUnauthorized reports a request without valid credentials.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden reports a caller lacking the permission a route requires.
+	ErrForbidden = errors.New("forbidden")
+)
+
+// Principal is the authenticated caller of a request, a user with a token
+// or a service account with an API key.
+type Principal struct {
+	// Subject is the user of a token or the name of an API key.
+	Subject string
+	Roles   model.Roles
+	// UserScoped callers only access their own subscriptions.
+	UserScoped bool
+}
+
+// Can reports whether the roles of the caller grant permission perm.
+func (p Principal) Can(perm model.Permission) bool {
+	return p.Roles.Grants(perm)
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the caller stored by the auth middleware.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// APIKeyAuthenticator looks up the API keys of service accounts.
+type APIKeyAuthenticator interface {
+	// Authenticate returns the key matching secret or an error wrapping
+	// service.ErrAPIKeyNotFound.
+	Authenticate(ctx context.Context, secret string) (*model.APIKey, error)
+}
+
+// AuthConfig configures authentication. Every request is rejected when
+// neither Verifier nor APIKeys is set, unless Disabled opts out of
+// authentication.
+type AuthConfig struct {
+	// Verifier checks bearer tokens. Users with AdminScope get the admin
+	// role, the others the writer role restricted to their own
+	// subscriptions.
+	Verifier *JWTVerifier
+	// AdminScope defaults to DefaultAdminScope.
+	AdminScope string
+	// APIKeys checks the X-API-Key header. Service accounts have the roles
+	// of their key and access the subscriptions of every user.
+	APIKeys APIKeyAuthenticator
+	// Disabled serves every request as an anonymous admin, it is meant for
+	// local runs only.
+	Disabled bool
+}
+
+// anonymous is the caller of every request when authentication is disabled.
+var anonymous = Principal{Subject: "anonymous", Roles: model.Roles{model.RoleAdmin}}
+
+// NewAuthMiddleware requires a valid API key or bearer token on every
+// request and stores the caller in the request context. The caller replaces
+// the X-Actor header in the audit trail, so it must run after
+// NewActorMiddleware. Rejected requests are answered by onError with an
+// error wrapping ErrUnauthorized.
+func NewAuthMiddleware(conf AuthConfig, onError func(http.ResponseWriter, *http.Request, error)) func(http.Handler) http.Handler {
+	if conf.AdminScope == "" {
+		conf.AdminScope = DefaultAdminScope
+	}
+	return func(next http.Handler) http.Handler {
+		if conf.Disabled {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, anonymous)))
+			})
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, err := authenticate(r, conf)
+			if err != nil {
+				onError(w, r, err)
+				return
+			}
+			ctx := context.WithValue(r.Context(), principalKey{}, p)
+			actor := service.ActorFromContext(ctx)
+			actor.Name = p.Subject
+			ctx = service.WithActor(ctx, actor)
+			if p.UserScoped {
+				ctx = service.WithUserScope(ctx, p.Subject)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// authenticate identifies the caller of r by its API key or, without one,
+// by its bearer token.
+func authenticate(r *http.Request, conf AuthConfig) (Principal, error) {
+	if secret := r.Header.Get(APIKeyHeader); secret != "" && conf.APIKeys != nil {
+		key, err := conf.APIKeys.Authenticate(r.Context(), secret)
+		if errors.Is(err, service.ErrAPIKeyNotFound) {
+			return Principal{}, fmt.Errorf("%w: invalid API key", ErrUnauthorized)
+		}
+		if err != nil {
+			return Principal{}, err
+		}
+		return Principal{Subject: "api-key:" + key.Name, Roles: key.Roles}, nil
+	}
+
+	if conf.Verifier == nil && conf.APIKeys == nil {
+		return Principal{}, fmt.Errorf("%w: no authentication is configured", ErrUnauthorized)
+	}
+	token, ok := bearerToken(r)
+	if !ok || conf.Verifier == nil {
+		return Principal{}, fmt.Errorf("%w: API key or bearer token is required", ErrUnauthorized)
+	}
+	claims, err := conf.Verifier.Verify(token)
+	if err != nil {
+		return Principal{}, err
+	}
+	if slices.Contains(claims.Scopes, conf.AdminScope) {
+		return Principal{Subject: claims.Subject, Roles: model.Roles{model.RoleAdmin}}, nil
+	}
+	// The subject of a user token is the user_id its data is scoped to.
+	if _, err := uuid.Parse(claims.Subject); err != nil {
+		return Principal{}, fmt.Errorf("%w: token subject is not a user id", ErrUnauthorized)
+	}
+	return Principal{Subject: claims.Subject, Roles: model.Roles{model.RoleWriter}, UserScoped: true}, nil
+}
+
+// RequirePermission rejects callers whose roles do not grant perm with an
+// error wrapping ErrForbidden, and requests that did not go through the auth
+// middleware with an error wrapping ErrUnauthorized.
+func RequirePermission(perm model.Permission, onError func(http.ResponseWriter, *http.Request, error)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := PrincipalFromContext(r.Context())
+			if !ok {
+				onError(w, r, fmt.Errorf("%w: the caller is not authenticated", ErrUnauthorized))
+				return
+			}
+			if !p.Can(perm) {
+				onError(w, r, fmt.Errorf("%w: %s permission is required", ErrForbidden, perm))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bearerToken returns the token of the Authorization header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
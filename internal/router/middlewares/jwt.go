@@ -0,0 +1,125 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MicahParks/keyfunc/v3"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// minHMACSecretLen is the shortest secret accepted for HS* tokens.
+	minHMACSecretLen = 32
+	// clockSkew is tolerated when checking the exp and nbf claims.
+	clockSkew = time.Minute
+)
+
+// JWTConfig configures the verification of bearer tokens.
+type JWTConfig struct {
+	// HMACSecret verifies tokens signed with HS256, HS384 or HS512.
+	HMACSecret string
+	// JWKSFile is a JSON Web Key Set whose keys verify tokens signed with
+	// RS*, PS* and ES* algorithms.
+	JWKSFile string
+	// Issuer and Audience, when set, must match the iss and aud claims.
+	Issuer   string
+	Audience string
+}
+
+// JWTVerifier checks the signature and the registered claims of JSON Web
+// Tokens.
+type JWTVerifier struct {
+	parser *jwt.Parser
+	secret []byte
+	jwks   keyfunc.Keyfunc
+}
+
+// Claims are the claims of a verified token used by the service.
+type Claims struct {
+	Subject string
+	Scopes  []string
+}
+
+// tokenClaims are the claims decoded from a token. scope is space separated
+// as in RFC 8693, some issuers use a scp list instead.
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Scope string           `json:"scope"`
+	Scp   jwt.ClaimStrings `json:"scp"`
+}
+
+// NewJWTVerifier returns a verifier for conf, or nil if neither a secret nor
+// a JWKS file is configured.
+func NewJWTVerifier(conf JWTConfig) (*JWTVerifier, error) {
+	if conf.HMACSecret == "" && conf.JWKSFile == "" {
+		return nil, nil
+	}
+	v := &JWTVerifier{}
+	var methods []string
+	if conf.HMACSecret != "" {
+		if len(conf.HMACSecret) < minHMACSecretLen {
+			return nil, fmt.Errorf("HMAC secret must be at least %d bytes long", minHMACSecretLen)
+		}
+		v.secret = []byte(conf.HMACSecret)
+		methods = append(methods, "HS256", "HS384", "HS512")
+	}
+	if conf.JWKSFile != "" {
+		data, err := os.ReadFile(conf.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("read JWKS: %w", err)
+		}
+		if v.jwks, err = keyfunc.NewJWKSetJSON(json.RawMessage(data)); err != nil {
+			return nil, fmt.Errorf("parse JWKS %s: %w", conf.JWKSFile, err)
+		}
+		methods = append(methods, "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512")
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(clockSkew),
+	}
+	if conf.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(conf.Issuer))
+	}
+	if conf.Audience != "" {
+		opts = append(opts, jwt.WithAudience(conf.Audience))
+	}
+	v.parser = jwt.NewParser(opts...)
+	return v, nil
+}
+
+// Verify checks token and returns its claims. Tokens must carry a subject
+// and an expiry. Failures wrap ErrUnauthorized.
+func (v *JWTVerifier) Verify(token string) (*Claims, error) {
+	var claims tokenClaims
+	if _, err := v.parser.ParseWithClaims(token, &claims, v.key); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: token has no subject", ErrUnauthorized)
+	}
+
+	scopes := strings.Fields(claims.Scope)
+	for _, s := range claims.Scp {
+		scopes = append(scopes, strings.Fields(s)...)
+	}
+	return &Claims{Subject: claims.Subject, Scopes: scopes}, nil
+}
+
+// key returns the secret for HS* tokens and looks up the JWKS key for the
+// others, so that a JWKS cannot supply HMAC keys.
+func (v *JWTVerifier) key(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return v.secret, nil
+	}
+	if v.jwks == nil {
+		return nil, errors.New("no JWKS is configured")
+	}
+	return v.jwks.Keyfunc(token)
+}
@@ -17,14 +17,14 @@ type (
 		size   int
 	}
 
-	loggingResponseWriter struct {
+	statusResponseWriter struct {
 		http.ResponseWriter
 		responseData *responseData
 		wroteHeader  bool
 	}
 )
 
-func (r *loggingResponseWriter) WriteHeader(statusCode int) {
+func (r *statusResponseWriter) WriteHeader(statusCode int) {
 	if !r.wroteHeader {
 		r.ResponseWriter.WriteHeader(statusCode)
 		r.responseData.status = statusCode
@@ -32,7 +32,7 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	}
 }
 
-func (r *loggingResponseWriter) Write(b []byte) (int, error) {
+func (r *statusResponseWriter) Write(b []byte) (int, error) {
 	if !r.wroteHeader {
 		r.WriteHeader(http.StatusOK)
 	}
@@ -48,7 +48,7 @@ func NewLoggingMiddleware(log Logger) func(http.Handler) http.Handler {
 			start := time.Now()
 
 			responseData := &responseData{}
-			lw := loggingResponseWriter{
+			lw := statusResponseWriter{
 				ResponseWriter: w,
 				responseData:   responseData,
 			}
@@ -0,0 +1,91 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// unmatchedRoute labels requests that matched no route, so that arbitrary
+// paths do not create new series.
+const unmatchedRoute = "unmatched"
+
+var knownMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+}
+
+// NewMetricsMiddleware registers HTTP metrics with reg and records every
+// request in them, labelled by chi route pattern, method and status.
+// Requests aborted by a panic that no response was written for count as
+// 500.
+func NewMetricsMiddleware(reg prometheus.Registerer) func(http.Handler) http.Handler {
+	labels := []string{"route", "method", "status"}
+	factory := promauto.With(reg)
+	requests := factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by route, method and status.",
+	}, labels)
+	duration := factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Duration of HTTP requests by route, method and status.",
+		Buckets: prometheus.DefBuckets,
+	}, labels)
+	size := factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_response_size_bytes",
+		Help:    "Size of HTTP response bodies by route, method and status.",
+		Buckets: prometheus.ExponentialBuckets(100, 10, 7),
+	}, labels)
+	inFlight := factory.NewGauge(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "HTTP requests being served.",
+	})
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			inFlight.Inc()
+			data := &responseData{}
+			sw := &statusResponseWriter{ResponseWriter: w, responseData: data}
+			completed := false
+			defer func() {
+				inFlight.Dec()
+				status := data.status
+				if status == 0 {
+					status = http.StatusOK
+					if !completed {
+						status = http.StatusInternalServerError
+					}
+				}
+				values := []string{routePattern(r), method(r), strconv.Itoa(status)}
+				requests.WithLabelValues(values...).Inc()
+				duration.WithLabelValues(values...).Observe(time.Since(start).Seconds())
+				size.WithLabelValues(values...).Observe(float64(data.size))
+			}()
+			next.ServeHTTP(sw, r)
+			completed = true
+		})
+	}
+}
+
+// routePattern returns the pattern of the chi route that served r, it is
+// only known after routing.
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		if pattern := rctx.RoutePattern(); pattern != "" {
+			return pattern
+		}
+	}
+	return unmatchedRoute
+}
+
+func method(r *http.Request) string {
+	if knownMethods[r.Method] {
+		return r.Method
+	}
+	return "OTHER"
+}
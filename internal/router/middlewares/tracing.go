@@ -0,0 +1,52 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/DeneesK/sub-service/internal/router/middlewares"
+
+// NewTracingMiddleware starts a server span for every request, continuing
+// the trace of the caller passed in the traceparent header. The span is
+// named after the chi route pattern once the request is routed and marked
+// failed for 5xx responses.
+func NewTracingMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := otel.Tracer(tracerName).Start(ctx, method(r),
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("http.request.method", r.Method),
+					attribute.String("url.path", r.URL.Path),
+				),
+			)
+			defer span.End()
+
+			data := &responseData{}
+			sw := &statusResponseWriter{ResponseWriter: w, responseData: data}
+			r = r.WithContext(ctx)
+			next.ServeHTTP(sw, r)
+
+			route := routePattern(r)
+			status := data.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			span.SetName(method(r) + " " + route)
+			span.SetAttributes(
+				attribute.String("http.route", route),
+				attribute.Int("http.response.status_code", status),
+			)
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/DeneesK/sub-service/internal/model"
+)
+
+// SetCurrencyRates
+// @Summary Set currency rates
+// @Description Store monthly exchange rates used to convert aggregates, replacing the rates of the same currency and month. A rate is the price of one unit of the currency in RUB
+// @Tags currency rates
+// @Accept json
+// @Produce json
+// @Param rates body []model.CurrencyRate true "Rates"
+// @Success 204 {string} string "No Content"
+// @Failure 400 {object} router.Problem "Bad Request"
+// @Failure 401 {object} router.Problem "Unauthorized"
+// @Failure 403 {object} router.Problem "Forbidden"
+// @Failure 500 {object} router.Problem "Internal Server Error"
+// @Failure 503 {object} router.Problem "Request cancelled"
+// @Failure 504 {object} router.Problem "Request timed out"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/currency-rates [put]
+func (h *SubscriptionHandler) SetRates(w http.ResponseWriter, r *http.Request) {
+	var rates []model.CurrencyRate
+	if err := decodeBody(r, &rates); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	if err := h.svc.SetRates(r.Context(), rates); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListCurrencyRates
+// @Summary List currency rates
+// @Description Get the stored exchange rates ordered by currency and month
+// @Tags currency rates
+// @Produce json
+// @Param currency query string false "Only rates of the currency (optional)"
+// @Success 200 {array} model.CurrencyRate
+// @Failure 400 {object} router.Problem "Bad Request"
+// @Failure 401 {object} router.Problem "Unauthorized"
+// @Failure 403 {object} router.Problem "Forbidden"
+// @Failure 500 {object} router.Problem "Internal Server Error"
+// @Failure 503 {object} router.Problem "Request cancelled"
+// @Failure 504 {object} router.Problem "Request timed out"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/currency-rates [get]
+func (h *SubscriptionHandler) ListRates(w http.ResponseWriter, r *http.Request) {
+	rates, err := h.svc.Rates(r.Context(), r.URL.Query().Get("currency"))
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(rates)
+}
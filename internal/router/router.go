@@ -1,6 +1,7 @@
 package router
 
 import (
+	"context"
 	"time"
 
 	_ "github.com/DeneesK/sub-service/api/docs"
@@ -8,37 +9,91 @@ import (
 	"github.com/DeneesK/sub-service/internal/router/middlewares"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	httpSwagger "github.com/swaggo/http-swagger"
 	"go.uber.org/zap"
 )
 
 type SubService interface {
-	Create(sub *model.Subscription) error
-	Get(id string) (*model.Subscription, error)
-	List(userID string) ([]model.Subscription, error)
-	Update(id string, upd *model.UpdateSubscription) error
-	Delete(id string) error
-	Aggregate(from, to time.Time, userID, service string) (int, error)
+	Create(ctx context.Context, sub *model.Subscription) error
+	Get(ctx context.Context, id string, includeDeleted bool) (*model.Subscription, error)
+	List(ctx context.Context, f model.ListFilter) (*model.SubscriptionPage, error)
+	Export(ctx context.Context, f model.ListFilter, fn func(sub *model.Subscription) error) error
+	Update(ctx context.Context, id string, version int64, upd *model.UpdateSubscription) (*model.Subscription, error)
+	Delete(ctx context.Context, id string, version int64) error
+	Restore(ctx context.Context, id string) (*model.Subscription, error)
+	History(ctx context.Context, id string) ([]model.SubscriptionEvent, error)
+	Batch(ctx context.Context, mode model.BatchMode, ops []model.BatchOperation) (*model.BatchResult, error)
+	Import(ctx context.Context, src model.SubscriptionReader, dryRun bool) (*model.ImportResult, error)
+	Aggregate(ctx context.Context, f model.AggregateFilter) (int, error)
+	AggregateMonthly(ctx context.Context, f model.AggregateFilter) ([]model.MonthlyAggregate, error)
+	AggregateGroups(ctx context.Context, f model.AggregateFilter) ([]model.AggregateGroup, error)
+	SetRates(ctx context.Context, rates []model.CurrencyRate) error
+	Rates(ctx context.Context, currency string) ([]model.CurrencyRate, error)
 }
 
-func NewRouter(timeOut time.Duration, subService SubService, log *zap.SugaredLogger) *chi.Mux {
+// APIKeyService manages the API keys of service accounts.
+type APIKeyService interface {
+	CreateAPIKey(ctx context.Context, key *model.APIKey) (string, error)
+	ListAPIKeys(ctx context.Context) ([]model.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+}
+
+// NewRouter builds the HTTP API. Every request is traced with the global
+// tracer provider. Requests are measured and /metrics is served unless
+// metrics is nil. /healthz and /readyz serve the liveness and readiness
+// probes, the instance is ready while ready succeeds.
+func NewRouter(
+	timeOut time.Duration, subService SubService, keyService APIKeyService,
+	idempotency IdempotencyConfig, auth middlewares.AuthConfig, metrics *prometheus.Registry,
+	ready ReadinessCheck, log *zap.SugaredLogger,
+) *chi.Mux {
 	r := chi.NewRouter()
+	r.NotFound(notFound)
+	r.MethodNotAllowed(methodNotAllowed)
 
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
+	r.Use(middlewares.NewTracingMiddleware())
+	if metrics != nil {
+		r.Use(middlewares.NewMetricsMiddleware(metrics))
+	}
 	r.Use(middleware.Recoverer)
 	r.Use(middlewares.NewLoggingMiddleware(log))
-	r.Use(middleware.Timeout(timeOut * time.Second))
+	r.Use(middlewares.NewActorMiddleware())
+	r.Use(middleware.Timeout(timeOut))
 
-	h := NewSubscriptionHandler(subService, log)
+	h := NewSubscriptionHandler(subService, keyService, log)
 	r.Get("/swagger/*", httpSwagger.WrapHandler)
+	r.Get("/healthz", healthz)
+	r.Get("/readyz", readyz(ready, log))
+	if metrics != nil {
+		r.Handle("/metrics", promhttp.HandlerFor(metrics, promhttp.HandlerOpts{Registry: metrics}))
+	}
 	r.Route("/api/v1", func(r chi.Router) {
-		r.Post("/subs", h.Create)
-		r.Get("/subs", h.List)
-		r.Get("/subs/{id}", h.Get)
-		r.Patch("/subs/{id}", h.Update)
-		r.Delete("/subs/{id}", h.Delete)
-		r.Get("/subs/aggregate", h.Aggregate)
+		r.Use(middlewares.NewAuthMiddleware(auth, authFailed))
+		read := r.With(middlewares.RequirePermission(model.PermissionRead, authFailed))
+		write := r.With(middlewares.RequirePermission(model.PermissionWrite, authFailed))
+		admin := r.With(middlewares.RequirePermission(model.PermissionAdmin, authFailed))
+
+		idempotent := write.With(newIdempotencyMiddleware(idempotency, log))
+		idempotent.Post("/subs", h.Create)
+		idempotent.Post("/subs/batch", h.Batch)
+		write.Post("/subs/import", h.Import)
+		read.Get("/subs", h.List)
+		read.Get("/subs/{id}", h.Get)
+		write.Patch("/subs/{id}", h.Update)
+		write.Delete("/subs/{id}", h.Delete)
+		write.Post("/subs/{id}/restore", h.Restore)
+		read.Get("/subs/{id}/history", h.History)
+		read.Get("/subs/aggregate", h.Aggregate)
+		read.Get("/subs/aggregate/monthly", h.AggregateMonthly)
+		admin.Put("/admin/currency-rates", h.SetRates)
+		admin.Get("/admin/currency-rates", h.ListRates)
+		admin.Post("/admin/api-keys", h.CreateAPIKey)
+		admin.Get("/admin/api-keys", h.ListAPIKeys)
+		admin.Delete("/admin/api-keys/{id}", h.RevokeAPIKey)
 	})
 	return r
 }
@@ -0,0 +1,133 @@
+package router
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// The parts of a minimal XLSX workbook with a single worksheet.
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`
+	xlsxSheetStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	xlsxSheetEnd = `</sheetData></worksheet>`
+)
+
+// xlsxWriter streams a table as the only worksheet of an XLSX workbook.
+// Strings are stored inline, so rows can be written as they come.
+type xlsxWriter struct {
+	zip   *zip.Writer
+	name  string
+	sheet *bufio.Writer
+	rows  int
+}
+
+func newXLSXWriter(w io.Writer, name string) *xlsxWriter {
+	return &xlsxWriter{zip: zip.NewWriter(w), name: name}
+}
+
+func (x *xlsxWriter) Header(columns []string) error {
+	var name bytesWriter
+	xml.EscapeText(&name, []byte(x.name))
+	parts := []struct{ path, content string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, name)},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	}
+	for _, part := range parts {
+		f, err := x.zip.Create(part.path)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return err
+		}
+	}
+
+	// The worksheet is the last part, the rows are appended to it.
+	f, err := x.zip.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	x.sheet = bufio.NewWriter(f)
+	x.sheet.WriteString(xlsxSheetStart)
+
+	values := make([]interface{}, len(columns))
+	for i, column := range columns {
+		values[i] = column
+	}
+	return x.Row(values...)
+}
+
+func (x *xlsxWriter) Row(values ...interface{}) error {
+	x.rows++
+	row := strconv.Itoa(x.rows)
+	x.sheet.WriteString(`<row r="` + row + `">`)
+	for i, v := range values {
+		ref := xlsxColumn(i) + row
+		switch v := v.(type) {
+		case nil:
+		case string:
+			x.sheet.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">`)
+			if err := xml.EscapeText(x.sheet, []byte(v)); err != nil {
+				return err
+			}
+			x.sheet.WriteString(`</t></is></c>`)
+		default:
+			fmt.Fprintf(x.sheet, `<c r="%s"><v>%v</v></c>`, ref, v)
+		}
+	}
+	_, err := x.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (x *xlsxWriter) Close() error {
+	x.sheet.WriteString(xlsxSheetEnd)
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+	return x.zip.Close()
+}
+
+// xlsxColumn returns the letters of the zero based column i: A, ..., Z, AA, ...
+func xlsxColumn(i int) string {
+	var name []byte
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = append([]byte{byte('A' + (i-1)%26)}, name...)
+	}
+	return string(name)
+}
+
+// bytesWriter collects escaped text.
+type bytesWriter []byte
+
+func (b *bytesWriter) Write(p []byte) (int, error) {
+	*b = append(*b, p...)
+	return len(p), nil
+}
+
+func (b bytesWriter) String() string {
+	return string(b)
+}
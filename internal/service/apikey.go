@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/DeneesK/sub-service/internal/model"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	// apiKeyPrefix starts every generated key so that leaked keys are easy
+	// to spot.
+	apiKeyPrefix = "sk_"
+	// apiKeyPrefixLen is how much of a key is kept in clear to identify it.
+	apiKeyPrefixLen  = len(apiKeyPrefix) + 8
+	apiKeyRandomSize = 32
+	maxAPIKeyNameLen = 100
+
+	bootstrapAPIKeyName   = "bootstrap"
+	minBootstrapAPIKeyLen = 32
+)
+
+// APIKeyRepository persists API keys by the hash of the key.
+// Implementations report a missing key with ErrAPIKeyNotFound.
+type APIKeyRepository interface {
+	// CreateAPIKey stores key with the hash of its secret and fills in its
+	// id and creation time.
+	CreateAPIKey(ctx context.Context, key *model.APIKey, hash string) error
+	// GetAPIKeyByHash returns the key with the hash, revoked or not.
+	GetAPIKeyByHash(ctx context.Context, hash string) (*model.APIKey, error)
+	// ListAPIKeys returns every key, revoked ones included, oldest first.
+	ListAPIKeys(ctx context.Context) ([]model.APIKey, error)
+	// RevokeAPIKey revokes a key that is not revoked yet.
+	RevokeAPIKey(ctx context.Context, id string) error
+}
+
+// APIKeyService manages the API keys of service accounts.
+type APIKeyService struct {
+	repo APIKeyRepository
+	log  *zap.SugaredLogger
+}
+
+func NewAPIKeyService(repo APIKeyRepository, log *zap.SugaredLogger) *APIKeyService {
+	return &APIKeyService{repo: repo, log: log}
+}
+
+// CreateAPIKey generates a key with the name and roles of key and stores
+// its hash. The returned key cannot be retrieved again.
+func (s *APIKeyService) CreateAPIKey(ctx context.Context, key *model.APIKey) (string, error) {
+	if err := prepareAPIKey(key); err != nil {
+		return "", err
+	}
+	random := make([]byte, apiKeyRandomSize)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	secret := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(random)
+	if err := s.store(ctx, key, secret); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// BootstrapAPIKey stores secret as an admin key unless it is already known,
+// so that the first keys can be created without another credential.
+func (s *APIKeyService) BootstrapAPIKey(ctx context.Context, secret string) error {
+	if len(secret) < minBootstrapAPIKeyLen {
+		return fmt.Errorf("bootstrap API key must be at least %d characters long", minBootstrapAPIKeyLen)
+	}
+	_, err := s.repo.GetAPIKeyByHash(ctx, hashAPIKey(secret))
+	if !errors.Is(err, ErrAPIKeyNotFound) {
+		return err
+	}
+	return s.store(ctx, &model.APIKey{Name: bootstrapAPIKeyName, Roles: model.Roles{model.RoleAdmin}}, secret)
+}
+
+func (s *APIKeyService) store(ctx context.Context, key *model.APIKey, secret string) error {
+	key.Prefix = secret[:min(apiKeyPrefixLen, len(secret))]
+	if err := s.repo.CreateAPIKey(ctx, key, hashAPIKey(secret)); err != nil {
+		return err
+	}
+	s.log.Infof("created API key %s %q with roles %v", key.ID, key.Name, key.Roles)
+	return nil
+}
+
+// ListAPIKeys returns every key, revoked ones included, oldest first.
+func (s *APIKeyService) ListAPIKeys(ctx context.Context) ([]model.APIKey, error) {
+	keys, err := s.repo.ListAPIKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		keys = []model.APIKey{}
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey makes the key unusable, it stays listed.
+func (s *APIKeyService) RevokeAPIKey(ctx context.Context, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalidAPIKeyID, id)
+	}
+	if err := s.repo.RevokeAPIKey(ctx, id); err != nil {
+		return err
+	}
+	s.log.Infof("revoked API key %s", id)
+	return nil
+}
+
+// Authenticate returns the key matching secret, revoked and unknown keys
+// are reported with ErrAPIKeyNotFound.
+func (s *APIKeyService) Authenticate(ctx context.Context, secret string) (*model.APIKey, error) {
+	key, err := s.repo.GetAPIKeyByHash(ctx, hashAPIKey(secret))
+	if err != nil {
+		return nil, err
+	}
+	if key.RevokedAt != nil {
+		return nil, ErrAPIKeyNotFound
+	}
+	return key, nil
+}
+
+// prepareAPIKey normalizes and validates a key to be created.
+func prepareAPIKey(key *model.APIKey) error {
+	var vErr ValidationError
+	key.Name = strings.TrimSpace(key.Name)
+	if key.Name == "" || len(key.Name) > maxAPIKeyNameLen {
+		vErr.Add("name", fmt.Sprintf("must be 1 to %d characters long", maxAPIKeyNameLen))
+	}
+	if len(key.Roles) == 0 {
+		vErr.Add("roles", "is required")
+	}
+	for i, role := range key.Roles {
+		if !role.IsValid() {
+			vErr.Add(fmt.Sprintf("roles[%d]", i), "must be one of reader, writer, admin")
+		}
+	}
+	slices.Sort(key.Roles)
+	key.Roles = slices.Compact(key.Roles)
+	return vErr.Err()
+}
+
+// hashAPIKey returns the stored form of a key. Keys are random enough that
+// a fast hash cannot be reversed.
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/DeneesK/sub-service/internal/model"
+)
+
+const anonymousActor = "anonymous"
+
+// Actor identifies who performs a change, it is recorded in the audit trail.
+type Actor struct {
+	Name      string
+	RequestID string
+}
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx carrying the actor of the changes made
+// with it.
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor stored by WithActor, changes made
+// without one are attributed to an anonymous actor.
+func ActorFromContext(ctx context.Context) Actor {
+	actor, _ := ctx.Value(actorKey{}).(Actor)
+	if actor.Name == "" {
+		actor.Name = anonymousActor
+	}
+	return actor
+}
+
+// fieldChange is a single entry of an event diff.
+type fieldChange struct {
+	From json.RawMessage `json:"from"`
+	To   json.RawMessage `json:"to"`
+}
+
+// newEvent builds the audit event of a change of subscription id from
+// before to after, either of which may be nil.
+func newEvent(
+	ctx context.Context, action model.EventAction, id string, before, after *model.Subscription,
+) (*model.SubscriptionEvent, error) {
+	actor := ActorFromContext(ctx)
+	e := &model.SubscriptionEvent{
+		SubscriptionID: id,
+		Action:         action,
+		Actor:          actor.Name,
+		RequestID:      actor.RequestID,
+	}
+
+	beforeFields, err := snapshot(before, &e.Before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := snapshot(after, &e.After)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(beforeFields)+len(afterFields))
+	for name := range beforeFields {
+		names = append(names, name)
+	}
+	for name := range afterFields {
+		if _, ok := beforeFields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	diff := make(map[string]fieldChange)
+	null := json.RawMessage("null")
+	for _, name := range names {
+		from, to := beforeFields[name], afterFields[name]
+		// The version changes with every event, it carries no information.
+		if name == "version" || bytes.Equal(from, to) {
+			continue
+		}
+		change := fieldChange{From: from, To: to}
+		if change.From == nil {
+			change.From = null
+		}
+		if change.To == nil {
+			change.To = null
+		}
+		diff[name] = change
+	}
+	e.Diff, err = json.Marshal(diff)
+	return e, err
+}
+
+// snapshot stores the JSON document of sub in dst and returns its fields.
+func snapshot(sub *model.Subscription, dst *model.JSONB) (map[string]json.RawMessage, error) {
+	if sub == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(sub)
+	if err != nil {
+		return nil, err
+	}
+	*dst = data
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(data, &fields)
+	return fields, err
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/DeneesK/sub-service/internal/model"
+)
+
+const maxBatchOperations = 50000
+
+// Batch applies the operations. The creates come first, stored together
+// with as few round trips as possible, then the updates and deletes in the
+// order they were submitted, so these see every subscription the batch
+// creates wherever it is listed. In atomic mode either all of them are
+// applied or, if any fails, none. In best effort mode every operation is
+// applied on its own and the failures are reported per operation.
+func (s *SubscriptionService) Batch(ctx context.Context, mode model.BatchMode, ops []model.BatchOperation) (_ *model.BatchResult, err error) {
+	ctx, end := startSpan(ctx, "SubscriptionService.Batch")
+	defer end(&err)
+
+	if mode == "" {
+		mode = model.BatchAtomic
+	}
+	var vErr ValidationError
+	if mode != model.BatchAtomic && mode != model.BatchBestEffort {
+		vErr.Add("mode", "must be one of atomic, best_effort")
+	}
+	if len(ops) == 0 || len(ops) > maxBatchOperations {
+		vErr.Add("operations", fmt.Sprintf("must contain 1 to %d operations", maxBatchOperations))
+	}
+	if err := vErr.Err(); err != nil {
+		return nil, err
+	}
+
+	res := &model.BatchResult{Items: make([]model.BatchItemResult, len(ops))}
+	var creates []int
+	for i := range ops {
+		op := &ops[i]
+		res.Items[i] = model.BatchItemResult{Index: i, Action: op.Action, ID: op.ID}
+		if op.Action == model.BatchCreate {
+			scopeSubscription(ctx, op.Subscription)
+		}
+		if err := validateOperation(op); err != nil {
+			res.Items[i].Err = err
+			continue
+		}
+		if op.Action == model.BatchCreate {
+			creates = append(creates, i)
+		}
+	}
+
+	if mode == model.BatchAtomic {
+		err = s.batchAtomic(ctx, ops, creates, res)
+	} else {
+		err = s.batchBestEffort(ctx, ops, creates, res)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.log.Debugf("applied %s batch of %d operations, committed %t", mode, len(ops), res.Committed)
+	return res, nil
+}
+
+func (s *SubscriptionService) batchAtomic(ctx context.Context, ops []model.BatchOperation, creates []int, res *model.BatchResult) error {
+	for _, item := range res.Items {
+		if item.Err != nil {
+			rollBack(res)
+			return nil
+		}
+	}
+
+	var failed bool
+	err := s.repo.InTx(ctx, func(repo SubscriptionRepository) error {
+		if err := s.createMany(ctx, repo, ops, creates, res); err != nil {
+			failed = true
+			return err
+		}
+		for i := range ops {
+			if ops[i].Action == model.BatchCreate {
+				continue
+			}
+			if err := s.apply(ctx, repo, &ops[i], &res.Items[i]); err != nil {
+				failed = true
+				return err
+			}
+		}
+		return nil
+	})
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if err != nil && !failed {
+		return err
+	}
+	if err != nil {
+		rollBack(res)
+		return nil
+	}
+	res.Committed = true
+	return nil
+}
+
+func (s *SubscriptionService) batchBestEffort(ctx context.Context, ops []model.BatchOperation, creates []int, res *model.BatchResult) error {
+	err := s.repo.InTx(ctx, func(repo SubscriptionRepository) error {
+		return s.createMany(ctx, repo, ops, creates, res)
+	})
+	if err != nil && ctx.Err() == nil {
+		// Store the creates one by one to tell which of them fail.
+		for _, i := range creates {
+			s.bestEffort(ctx, &res.Items[i], func(repo SubscriptionRepository) error {
+				return s.createMany(ctx, repo, ops, []int{i}, res)
+			})
+		}
+	} else if err != nil {
+		failItems(res, creates, err)
+	}
+
+	for i := range ops {
+		if ops[i].Action == model.BatchCreate || res.Items[i].Err != nil {
+			continue
+		}
+		s.bestEffort(ctx, &res.Items[i], func(repo SubscriptionRepository) error {
+			return s.apply(ctx, repo, &ops[i], &res.Items[i])
+		})
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	res.Committed = true
+	return nil
+}
+
+// bestEffort runs a single operation in its own transaction and records
+// its failure in item.
+func (s *SubscriptionService) bestEffort(ctx context.Context, item *model.BatchItemResult, fn func(repo SubscriptionRepository) error) {
+	if err := s.repo.InTx(ctx, fn); err != nil {
+		item.Err = err
+		item.Subscription = nil
+		if item.Action == model.BatchCreate {
+			item.ID = ""
+		}
+	}
+}
+
+// createMany stores the subscriptions of the create operations at the given
+// indexes and records their creation. On failure the error is reported for
+// all of them.
+func (s *SubscriptionService) createMany(
+	ctx context.Context, repo SubscriptionRepository, ops []model.BatchOperation, indexes []int, res *model.BatchResult,
+) error {
+	if len(indexes) == 0 {
+		return nil
+	}
+	subs := make([]*model.Subscription, len(indexes))
+	for n, i := range indexes {
+		subs[n] = ops[i].Subscription
+	}
+	err := repo.CreateMany(ctx, subs)
+	if err == nil {
+		events := make([]*model.SubscriptionEvent, len(subs))
+		for n, sub := range subs {
+			if events[n], err = newEvent(ctx, model.EventCreate, sub.ID, nil, sub); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = repo.AddEvents(ctx, events...)
+		}
+	}
+	if err != nil {
+		failItems(res, indexes, err)
+		return err
+	}
+	for n, i := range indexes {
+		res.Items[i].ID = subs[n].ID
+		res.Items[i].Subscription = subs[n]
+		res.Items[i].Err = nil
+	}
+	return nil
+}
+
+// failItems reports err for the operations at the given indexes.
+func failItems(res *model.BatchResult, indexes []int, err error) {
+	for _, i := range indexes {
+		res.Items[i].Err = err
+	}
+}
+
+// apply runs an update or delete operation within the transaction of repo
+// and stores its outcome in item.
+func (s *SubscriptionService) apply(ctx context.Context, repo SubscriptionRepository, op *model.BatchOperation, item *model.BatchItemResult) error {
+	switch op.Action {
+	case model.BatchUpdate:
+		item.Subscription, item.Err = s.update(ctx, repo, op.ID, op.Version, op.Update)
+	case model.BatchDelete:
+		item.Err = s.delete(ctx, repo, op.ID, op.Version)
+	}
+	return item.Err
+}
+
+// validateOperation checks what can be checked without the current state of
+// the subscriptions.
+func validateOperation(op *model.BatchOperation) error {
+	var vErr ValidationError
+	switch op.Action {
+	case model.BatchCreate:
+		if op.Subscription == nil {
+			vErr.Add("subscription", "is required")
+			break
+		}
+		return prepareCreate(op.Subscription)
+	case model.BatchUpdate:
+		if op.Update == nil {
+			vErr.Add("update", "is required")
+		}
+		fallthrough
+	case model.BatchDelete:
+		if err := validateID(op.ID); err != nil {
+			vErr.Add("id", "must be a UUID")
+		}
+	default:
+		vErr.Add("action", "must be one of create, update, delete")
+	}
+	return vErr.Err()
+}
+
+// rollBack marks every operation of an atomic batch that did not fail itself
+// as not applied.
+func rollBack(res *model.BatchResult) {
+	for i := range res.Items {
+		item := &res.Items[i]
+		if item.Err == nil {
+			item.Err = ErrRolledBack
+		}
+		item.Subscription = nil
+		if item.Action == model.BatchCreate {
+			item.ID = ""
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/DeneesK/sub-service/internal/model"
+	"github.com/google/uuid"
+)
+
+// encodeCursor returns the opaque cursor pointing after sub.
+func encodeCursor(sub model.Subscription, sort model.ListSort) string {
+	data, _ := json.Marshal(model.ListCursor{
+		StartDate: sub.StartDate.Time,
+		ID:        sub.ID,
+		Sort:      sort,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor returned by encodeCursor, it rejects cursors
+// whose id is not a subscription id.
+func decodeCursor(s string) (*model.ListCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c model.ListCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	if _, err := uuid.Parse(c.ID); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/DeneesK/sub-service/internal/model"
+)
+
+// Errors reported by SubscriptionService. Repository implementations report
+// storage failures with the same values so callers can tell them apart with
+// errors.Is regardless of the backend.
+var (
+	ErrNotFound   = errors.New("subscription not found")
+	ErrInvalidID  = errors.New("invalid subscription id")
+	ErrConflict   = errors.New("subscription conflicts with existing data")
+	ErrValidation = errors.New("validation failed")
+	// ErrPreconditionFailed reports that the subscription changed since the
+	// version the caller based its change on.
+	ErrPreconditionFailed = errors.New("subscription version does not match")
+	// ErrRolledBack is reported for batch operations that were not applied
+	// because another operation of the atomic batch failed.
+	ErrRolledBack = errors.New("not applied, the batch was rolled back")
+	// ErrMissingRates reports costs that cannot be converted to the requested
+	// currency because exchange rates are missing.
+	ErrMissingRates = errors.New("currency rates are missing")
+	// ErrAPIKeyNotFound reports an unknown or revoked API key.
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	// ErrInvalidAPIKeyID reports an API key id that is not a UUID.
+	ErrInvalidAPIKeyID = errors.New("invalid api key id")
+)
+
+// FieldError describes why a single input field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every rejected field of an input.
+// It matches ErrValidation with errors.Is.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Add(field, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+// Err returns e if any field was rejected and nil otherwise.
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Field+": "+f.Message)
+	}
+	return ErrValidation.Error() + ": " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// MissingRatesError lists the exchange rates an aggregate lacks.
+// It matches ErrMissingRates with errors.Is.
+type MissingRatesError struct {
+	Rates []model.MissingRate
+}
+
+func (e *MissingRatesError) Error() string {
+	msgs := make([]string, 0, len(e.Rates))
+	for _, r := range e.Rates {
+		msgs = append(msgs, r.Currency+" "+r.Month.Format("01-2006"))
+	}
+	return ErrMissingRates.Error() + ": " + strings.Join(msgs, ", ")
+}
+
+func (e *MissingRatesError) Is(target error) bool {
+	return target == ErrMissingRates
+}
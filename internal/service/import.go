@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"sort"
+
+	"github.com/DeneesK/sub-service/internal/model"
+)
+
+const (
+	// importChunkSize is the number of rows stored together.
+	importChunkSize = 1000
+	// maxImportErrors bounds the rejected lines reported in detail.
+	maxImportErrors = 1000
+)
+
+// Import creates the subscriptions read from src. Rows go through the same
+// validation as Create and are stored in chunks, a row that fails does not
+// prevent the others from being stored. With dryRun the rows are only
+// validated.
+func (s *SubscriptionService) Import(ctx context.Context, src model.SubscriptionReader, dryRun bool) (_ *model.ImportResult, err error) {
+	ctx, end := startSpan(ctx, "SubscriptionService.Import")
+	defer end(&err)
+
+	res := &model.ImportResult{DryRun: dryRun}
+	var (
+		ops   []model.BatchOperation
+		lines []int
+	)
+	flush := func() error {
+		if len(ops) == 0 {
+			return nil
+		}
+		defer func() { ops, lines = ops[:0], lines[:0] }()
+		if dryRun {
+			res.Imported += len(ops)
+			return nil
+		}
+
+		batch := &model.BatchResult{Items: make([]model.BatchItemResult, len(ops))}
+		indexes := make([]int, len(ops))
+		for i := range ops {
+			batch.Items[i] = model.BatchItemResult{Index: i, Action: model.BatchCreate}
+			indexes[i] = i
+		}
+		if err := s.batchBestEffort(ctx, ops, indexes, batch); err != nil {
+			return err
+		}
+		for i, item := range batch.Items {
+			if item.Err != nil {
+				reject(res, lines[i], item.Err)
+				continue
+			}
+			res.Imported++
+		}
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		row, err := src.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		res.Total++
+
+		if row.Subscription != nil {
+			scopeSubscription(ctx, row.Subscription)
+			row.Err = mergeFieldErrors(row.Err, prepareCreate(row.Subscription))
+		}
+		if row.Err != nil {
+			reject(res, row.Line, row.Err)
+			continue
+		}
+		ops = append(ops, model.BatchOperation{Action: model.BatchCreate, Subscription: row.Subscription})
+		lines = append(lines, row.Line)
+		if len(ops) == importChunkSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	// Rows rejected when stored are reported after later invalid rows.
+	sort.SliceStable(res.Errors, func(i, j int) bool {
+		return res.Errors[i].Line < res.Errors[j].Line
+	})
+	s.log.Debugf("imported %d of %d subscriptions, dry run %t", res.Imported, res.Total, dryRun)
+	return res, nil
+}
+
+// reject counts a rejected line and keeps its error while there are few.
+func reject(res *model.ImportResult, line int, err error) {
+	res.Failed++
+	if len(res.Errors) < maxImportErrors {
+		res.Errors = append(res.Errors, model.ImportLineError{Line: line, Err: err})
+	}
+}
+
+// mergeFieldErrors adds the fields rejected by validated to the ValidationError
+// of the fields that could not be parsed, except for those fields, whose
+// parsing error says more than the check of their zero value.
+func mergeFieldErrors(parsed, validated error) error {
+	var pErr, vErr *ValidationError
+	if !errors.As(parsed, &pErr) || !errors.As(validated, &vErr) {
+		if parsed != nil {
+			return parsed
+		}
+		return validated
+	}
+	merged := &ValidationError{Fields: slices.Clone(pErr.Fields)}
+	for _, f := range vErr.Fields {
+		if !slices.ContainsFunc(pErr.Fields, func(p FieldError) bool { return p.Field == f.Field }) {
+			merged.Fields = append(merged.Fields, f)
+		}
+	}
+	return merged
+}
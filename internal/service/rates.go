@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/DeneesK/sub-service/internal/model"
+)
+
+// SetRates validates the currency exchange rates and stores them, replacing
+// the rates of the same currency and month.
+func (s *SubscriptionService) SetRates(ctx context.Context, rates []model.CurrencyRate) (err error) {
+	ctx, end := startSpan(ctx, "SubscriptionService.SetRates")
+	defer end(&err)
+
+	var vErr ValidationError
+	for i := range rates {
+		rate := &rates[i]
+		rate.Currency = strings.ToUpper(rate.Currency)
+		field := fmt.Sprintf("[%d]", i)
+		switch {
+		case !model.IsCurrencyCode(rate.Currency):
+			vErr.Add(field+".currency", "must be an ISO 4217 currency code")
+		case rate.Currency == model.DefaultCurrency:
+			vErr.Add(field+".currency", "rates are quoted in "+model.DefaultCurrency+", it has no rate")
+		}
+		if rate.Month.IsZero() {
+			vErr.Add(field+".month", "is required")
+		}
+		if rate.Rate <= 0 {
+			vErr.Add(field+".rate", "must be positive")
+		}
+	}
+	if err := vErr.Err(); err != nil {
+		return err
+	}
+
+	// A later rate of the same currency and month replaces an earlier one.
+	type key struct {
+		currency string
+		month    int64
+	}
+	index := make(map[key]int, len(rates))
+	unique := make([]model.CurrencyRate, 0, len(rates))
+	for _, rate := range rates {
+		k := key{rate.Currency, rate.Month.Unix()}
+		if i, ok := index[k]; ok {
+			unique[i] = rate
+			continue
+		}
+		index[k] = len(unique)
+		unique = append(unique, rate)
+	}
+	if err := s.repo.SetRates(ctx, unique); err != nil {
+		return err
+	}
+	s.log.Infof("stored %d currency rates", len(unique))
+	return nil
+}
+
+// Rates returns the stored rates of the currency, of all currencies if it
+// is empty.
+func (s *SubscriptionService) Rates(ctx context.Context, currency string) (_ []model.CurrencyRate, err error) {
+	ctx, end := startSpan(ctx, "SubscriptionService.Rates")
+	defer end(&err)
+
+	currency = strings.ToUpper(currency)
+	if currency != "" && !model.IsCurrencyCode(currency) {
+		var vErr ValidationError
+		vErr.Add("currency", "must be an ISO 4217 currency code")
+		return nil, vErr.Err()
+	}
+	return s.repo.ListRates(ctx, currency)
+}
+
+// LoadRatesFile stores the rates listed in a JSON file in the format of
+// SetRates and returns how many there were.
+func (s *SubscriptionService) LoadRatesFile(ctx context.Context, path string) (_ int, err error) {
+	ctx, end := startSpan(ctx, "SubscriptionService.LoadRatesFile")
+	defer end(&err)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var rates []model.CurrencyRate
+	if err := json.Unmarshal(data, &rates); err != nil {
+		return 0, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := s.SetRates(ctx, rates); err != nil {
+		return 0, fmt.Errorf("load %s: %w", path, err)
+	}
+	return len(rates), nil
+}
+
+// checkRates restricts the aggregate to the user scope of ctx, defaults its
+// currency and fails with MissingRatesError if costs selected by f cannot be
+// converted to it.
+func (s *SubscriptionService) checkRates(ctx context.Context, f *model.AggregateFilter) error {
+	scopeUserID(ctx, &f.UserID)
+	if f.Currency == "" {
+		f.Currency = model.DefaultCurrency
+	}
+	missing, err := s.repo.MissingRates(ctx, *f)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return &MissingRatesError{Rates: missing}
+	}
+	return nil
+}
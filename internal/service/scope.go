@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+
+	"github.com/DeneesK/sub-service/internal/model"
+)
+
+type userScopeKey struct{}
+
+// WithUserScope returns a copy of ctx restricting the operations made with
+// it to the subscriptions of userID: filters only select them, subscriptions
+// are created for userID and those of other users are not found.
+func WithUserScope(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userScopeKey{}, userID)
+}
+
+// userScope returns the user stored by WithUserScope.
+func userScope(ctx context.Context) (string, bool) {
+	userID, ok := ctx.Value(userScopeKey{}).(string)
+	return userID, ok
+}
+
+// scopeSubscription assigns sub to the user of the scope of ctx.
+func scopeSubscription(ctx context.Context, sub *model.Subscription) {
+	if userID, ok := userScope(ctx); ok && sub != nil {
+		sub.UserID = userID
+	}
+}
+
+// scopeUpdate keeps upd from moving a subscription to another user than
+// the one of the scope of ctx.
+func scopeUpdate(ctx context.Context, upd *model.UpdateSubscription) {
+	if userID, ok := userScope(ctx); ok && upd.UserID.Set {
+		upd.UserID = model.Some(userID)
+	}
+}
+
+// checkScope reports a subscription of a user outside the scope of ctx as
+// not found.
+func checkScope(ctx context.Context, sub *model.Subscription) error {
+	if userID, ok := userScope(ctx); ok && sub.UserID != userID {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// scopeUserID narrows the user filter of a list or aggregate to the user of
+// the scope of ctx.
+func scopeUserID(ctx context.Context, filter *string) {
+	if userID, ok := userScope(ctx); ok {
+		*filter = userID
+	}
+}
+
+// isScoped reports whether ctx is restricted to the subscriptions of a user.
+func isScoped(ctx context.Context) bool {
+	_, ok := userScope(ctx)
+	return ok
+}
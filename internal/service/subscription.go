@@ -1,31 +1,91 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/DeneesK/sub-service/internal/model"
-	"github.com/jmoiron/sqlx"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
+// SubscriptionRepository persists subscriptions and computes aggregates over them.
+// Implementations report a missing subscription with ErrNotFound.
+type SubscriptionRepository interface {
+	Create(ctx context.Context, sub *model.Subscription) error
+	// CreateMany stores all subscriptions with as few round trips as
+	// possible and fills in their ids.
+	CreateMany(ctx context.Context, subs []*model.Subscription) error
+	Get(ctx context.Context, id string, includeDeleted bool) (*model.Subscription, error)
+	// List returns at most f.Limit subscriptions following f.After in f.Sort order.
+	List(ctx context.Context, f model.ListFilter) ([]model.Subscription, error)
+	// Stream passes every subscription matching f, ignoring f.Limit, to fn in
+	// f.Sort order without loading them all at once. It stops at the first
+	// error returned by fn.
+	Stream(ctx context.Context, f model.ListFilter, fn func(sub *model.Subscription) error) error
+	// Update and Delete change the subscription only if it is still at the
+	// given version and increment it, otherwise they report
+	// ErrPreconditionFailed.
+	// Update returns the subscription as stored after the change.
+	Update(ctx context.Context, id string, version int64, upd *model.UpdateSubscription) (*model.Subscription, error)
+	// Delete marks the subscription deleted, Restore clears the mark. Restore
+	// reports ErrNotFound unless the subscription is deleted.
+	Delete(ctx context.Context, id string, version int64) error
+	Restore(ctx context.Context, id string) (*model.Subscription, error)
+	// Purge removes subscriptions deleted before the given time for good.
+	Purge(ctx context.Context, deletedBefore time.Time) (int64, error)
+	// Stats counts the subscriptions, active ones in the given month.
+	Stats(ctx context.Context, month time.Time) (*model.SubscriptionStats, error)
+	AddEvents(ctx context.Context, events ...*model.SubscriptionEvent) error
+	ListEvents(ctx context.Context, subscriptionID string) ([]model.SubscriptionEvent, error)
+	// InTx runs fn with a repository whose changes are applied atomically,
+	// either all of them if fn succeeds or none otherwise.
+	InTx(ctx context.Context, fn func(repo SubscriptionRepository) error) error
+	// Aggregates convert costs to f.Currency and leave out the ones that
+	// cannot be converted, MissingRates lists the rates they lack.
+	Aggregate(ctx context.Context, f model.AggregateFilter) (int, error)
+	AggregateMonthly(ctx context.Context, f model.AggregateFilter) ([]model.MonthlyAggregate, error)
+	AggregateGroups(ctx context.Context, f model.AggregateFilter) ([]model.AggregateGroup, error)
+	MissingRates(ctx context.Context, f model.AggregateFilter) ([]model.MissingRate, error)
+	// SetRates inserts the rates or replaces the stored ones of the same
+	// currency and month.
+	SetRates(ctx context.Context, rates []model.CurrencyRate) error
+	// ListRates returns the rates of the currency, of all currencies if it
+	// is empty, ordered by currency and month.
+	ListRates(ctx context.Context, currency string) ([]model.CurrencyRate, error)
+}
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
 type SubscriptionService struct {
-	db  *sqlx.DB
-	log *zap.SugaredLogger
+	repo SubscriptionRepository
+	log  *zap.SugaredLogger
 }
 
-func NewSubscriptionService(db *sqlx.DB, log *zap.SugaredLogger) *SubscriptionService {
-	return &SubscriptionService{db: db, log: log}
+func NewSubscriptionService(repo SubscriptionRepository, log *zap.SugaredLogger) *SubscriptionService {
+	return &SubscriptionService{repo: repo, log: log}
 }
 
-func (s *SubscriptionService) Create(sub *model.Subscription) error {
-	query := `INSERT INTO subscriptions (service_name, price, user_id, start_date, end_date)
-              VALUES ($1, $2, $3, $4, $5) RETURNING id`
-	err := s.db.QueryRow(
-		query,
-		sub.ServiceName, sub.Price, sub.UserID, sub.StartDate, sub.EndDate,
-	).Scan(&sub.ID)
+func (s *SubscriptionService) Create(ctx context.Context, sub *model.Subscription) (err error) {
+	ctx, end := startSpan(ctx, "SubscriptionService.Create")
+	defer end(&err)
+
+	scopeSubscription(ctx, sub)
+	if err := prepareCreate(sub); err != nil {
+		return err
+	}
+	err = s.repo.InTx(ctx, func(repo SubscriptionRepository) error {
+		if err := repo.Create(ctx, sub); err != nil {
+			return err
+		}
+		return s.record(ctx, repo, model.EventCreate, sub.ID, nil, sub)
+	})
 	if err != nil {
 		return err
 	}
@@ -33,81 +93,344 @@ func (s *SubscriptionService) Create(sub *model.Subscription) error {
 	return nil
 }
 
-func (s *SubscriptionService) Get(id string) (*model.Subscription, error) {
-	var sub model.Subscription
-	err := s.db.Get(&sub, "SELECT * FROM subscriptions WHERE id=$1", id)
-	return &sub, err
+// prepareCreate normalizes and validates a subscription to be created.
+func prepareCreate(sub *model.Subscription) error {
+	// An empty end_date stands for no end date, as in updates.
+	if sub.EndDate != nil && sub.EndDate.IsZero() {
+		sub.EndDate = nil
+	}
+	if sub.Currency == "" {
+		sub.Currency = model.DefaultCurrency
+	}
+	sub.Currency = strings.ToUpper(sub.Currency)
+	if sub.BillingPeriod == "" {
+		sub.BillingPeriod = model.DefaultBillingPeriod
+	}
+	if sub.BillingInterval == 0 {
+		sub.BillingInterval = model.DefaultBillingInterval
+	}
+	return validateSubscription(sub)
+}
+
+// prepareUpdate normalizes upd and validates it against the current state
+// of the subscription.
+func prepareUpdate(current *model.Subscription, upd *model.UpdateSubscription) error {
+	// Removing the currency or the billing cycle restores the default one.
+	if upd.Currency.Null {
+		upd.Currency = model.Some(model.DefaultCurrency)
+	}
+	upd.Currency.Value = strings.ToUpper(upd.Currency.Value)
+	if upd.BillingPeriod.Null {
+		upd.BillingPeriod = model.Some(model.DefaultBillingPeriod)
+	}
+	if upd.BillingInterval.Null {
+		upd.BillingInterval = model.Some(model.DefaultBillingInterval)
+	}
+	return validateUpdate(current, upd)
 }
 
-func (s *SubscriptionService) List(userID string) ([]model.Subscription, error) {
-	var subs []model.Subscription
-	var err error
+// Get returns the subscription with the given id. Soft deleted
+// subscriptions are reported as not found unless includeDeleted is set.
+func (s *SubscriptionService) Get(ctx context.Context, id string, includeDeleted bool) (_ *model.Subscription, err error) {
+	ctx, end := startSpan(ctx, "SubscriptionService.Get")
+	defer end(&err)
+
+	if err := validateID(id); err != nil {
+		return nil, err
+	}
+	sub, err := s.repo.Get(ctx, id, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkScope(ctx, sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// List returns a page of subscriptions matching the filter, ordered by
+// start date and id.
+func (s *SubscriptionService) List(ctx context.Context, f model.ListFilter) (_ *model.SubscriptionPage, err error) {
+	ctx, end := startSpan(ctx, "SubscriptionService.List")
+	defer end(&err)
+
+	scopeUserID(ctx, &f.UserID)
+	if err := validateListFilter(&f); err != nil {
+		return nil, err
+	}
+
+	// Fetch one extra row to tell whether there is a next page.
+	query := f
+	query.Limit = f.Limit + 1
+	subs, err := s.repo.List(ctx, query)
+	if err != nil {
+		return nil, err
+	}
 
-	if userID == "" {
-		query := `SELECT * FROM subscriptions ORDER BY start_date DESC`
-		err = s.db.Select(&subs, query)
-	} else {
-		query := `SELECT * FROM subscriptions WHERE user_id = $1 ORDER BY start_date DESC`
-		err = s.db.Select(&subs, query, userID)
+	page := &model.SubscriptionPage{Items: subs}
+	if len(subs) > f.Limit {
+		page.Items = subs[:f.Limit]
+		page.NextCursor = encodeCursor(page.Items[f.Limit-1], f.Sort)
 	}
+	if page.Items == nil {
+		page.Items = []model.Subscription{}
+	}
+	return page, nil
+}
 
-	return subs, err
+// Export passes every subscription matching the filter to fn, in the order
+// of List. The limit of the filter is ignored.
+func (s *SubscriptionService) Export(ctx context.Context, f model.ListFilter, fn func(sub *model.Subscription) error) (err error) {
+	ctx, end := startSpan(ctx, "SubscriptionService.Export")
+	defer end(&err)
+
+	scopeUserID(ctx, &f.UserID)
+	if err := validateListFilter(&f); err != nil {
+		return err
+	}
+	f.Limit = 0
+	return s.repo.Stream(ctx, f, fn)
 }
 
-func (s *SubscriptionService) Update(id string, upd *model.UpdateSubscription) error {
-	setClauses := []string{}
-	args := map[string]interface{}{"id": id}
+// Update merges upd into the subscription and returns the result. A non zero
+// version makes the update conditional, it fails with ErrPreconditionFailed
+// unless the subscription is still at that version.
+func (s *SubscriptionService) Update(ctx context.Context, id string, version int64, upd *model.UpdateSubscription) (_ *model.Subscription, err error) {
+	ctx, end := startSpan(ctx, "SubscriptionService.Update")
+	defer end(&err)
+
+	if err := validateID(id); err != nil {
+		return nil, err
+	}
+	var updated *model.Subscription
+	err = s.repo.InTx(ctx, func(repo SubscriptionRepository) (err error) {
+		updated, err = s.update(ctx, repo, id, version, upd)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
 
-	if upd.ServiceName != nil {
-		setClauses = append(setClauses, "service_name=:service_name")
-		args["service_name"] = *upd.ServiceName
+// update implements Update within the transaction of repo.
+func (s *SubscriptionService) update(
+	ctx context.Context, repo SubscriptionRepository, id string, version int64, upd *model.UpdateSubscription,
+) (*model.Subscription, error) {
+	current, err := repo.Get(ctx, id, false)
+	if err != nil {
+		return nil, err
 	}
-	if upd.Price != nil {
-		setClauses = append(setClauses, "price=:price")
-		args["price"] = *upd.Price
+	if err := checkScope(ctx, current); err != nil {
+		return nil, err
 	}
-	if upd.UserID != nil {
-		setClauses = append(setClauses, "user_id=:user_id")
-		args["user_id"] = *upd.UserID
+	if err := checkVersion(current, version); err != nil {
+		return nil, err
 	}
-	if upd.StartDate != nil {
-		setClauses = append(setClauses, "start_date=:start_date")
-		args["start_date"] = *upd.StartDate
+	scopeUpdate(ctx, upd)
+	if err := prepareUpdate(current, upd); err != nil {
+		return nil, err
 	}
-	if upd.EndDate != nil {
-		setClauses = append(setClauses, "end_date=:end_date")
-		args["end_date"] = *upd.EndDate
+	updated, err := repo.Update(ctx, id, current.Version, upd)
+	if err != nil {
+		return nil, concurrentChange(err, version)
 	}
+	return updated, s.record(ctx, repo, model.EventUpdate, id, current, updated)
+}
+
+// Delete soft deletes the subscription, it can be brought back with Restore
+// until it is purged. A non zero version makes the deletion conditional as
+// in Update.
+func (s *SubscriptionService) Delete(ctx context.Context, id string, version int64) (err error) {
+	ctx, end := startSpan(ctx, "SubscriptionService.Delete")
+	defer end(&err)
 
-	if len(setClauses) == 0 {
-		return nil
+	if err := validateID(id); err != nil {
+		return err
 	}
+	return s.repo.InTx(ctx, func(repo SubscriptionRepository) error {
+		return s.delete(ctx, repo, id, version)
+	})
+}
 
-	query := fmt.Sprintf(`UPDATE subscriptions SET %s WHERE id=:id`, strings.Join(setClauses, ", "))
-	_, err := s.db.NamedExec(query, args)
-	return err
+// delete implements Delete within the transaction of repo.
+func (s *SubscriptionService) delete(ctx context.Context, repo SubscriptionRepository, id string, version int64) error {
+	current, err := repo.Get(ctx, id, false)
+	if err != nil {
+		return err
+	}
+	if err := checkScope(ctx, current); err != nil {
+		return err
+	}
+	if err := checkVersion(current, version); err != nil {
+		return err
+	}
+	if err := repo.Delete(ctx, id, current.Version); err != nil {
+		return concurrentChange(err, version)
+	}
+	deleted, err := repo.Get(ctx, id, true)
+	if err != nil {
+		return err
+	}
+	return s.record(ctx, repo, model.EventDelete, id, current, deleted)
 }
 
-func (s *SubscriptionService) Delete(id string) error {
-	_, err := s.db.Exec("DELETE FROM subscriptions WHERE id=$1", id)
-	return err
+// Restore undoes Delete and returns the restored subscription. A
+// subscription that is not deleted is left as is and reported with
+// ErrConflict.
+func (s *SubscriptionService) Restore(ctx context.Context, id string) (_ *model.Subscription, err error) {
+	ctx, end := startSpan(ctx, "SubscriptionService.Restore")
+	defer end(&err)
+
+	if err := validateID(id); err != nil {
+		return nil, err
+	}
+	var restored *model.Subscription
+	err = s.repo.InTx(ctx, func(repo SubscriptionRepository) error {
+		current, err := repo.Get(ctx, id, true)
+		if err != nil {
+			return err
+		}
+		if err := checkScope(ctx, current); err != nil {
+			return err
+		}
+		if current.DeletedAt == nil {
+			return fmt.Errorf("%w: subscription %s is not deleted", ErrConflict, id)
+		}
+		restored, err = repo.Restore(ctx, id)
+		if err != nil {
+			return err
+		}
+		return s.record(ctx, repo, model.EventRestore, id, current, restored)
+	})
+	return restored, err
+}
+
+// History returns the audit trail of the subscription, oldest change first.
+func (s *SubscriptionService) History(ctx context.Context, id string) (_ []model.SubscriptionEvent, err error) {
+	ctx, end := startSpan(ctx, "SubscriptionService.History")
+	defer end(&err)
+
+	if err := validateID(id); err != nil {
+		return nil, err
+	}
+	events, err := s.repo.ListEvents(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// The trail of a subscription of another user must not be disclosed.
+	if len(events) == 0 || isScoped(ctx) {
+		if _, err := s.Get(ctx, id, true); err != nil {
+			return nil, err
+		}
+	}
+	if len(events) == 0 {
+		events = []model.SubscriptionEvent{}
+	}
+	return events, nil
 }
 
-func (s *SubscriptionService) Aggregate(
-	from, to time.Time, userID, service string,
-) (int, error) {
-	q := `SELECT COALESCE(SUM(price),0) FROM subscriptions
-          WHERE start_date >= $1 AND start_date <= $2`
-	args := []interface{}{from, to}
-	if userID != "" {
-		q += " AND user_id = $" + fmt.Sprint(len(args)+1)
-		args = append(args, userID)
+// record appends the change of subscription id from before to after to the
+// audit trail using repo, so it is stored in the same transaction.
+func (s *SubscriptionService) record(
+	ctx context.Context, repo SubscriptionRepository,
+	action model.EventAction, id string, before, after *model.Subscription,
+) error {
+	e, err := newEvent(ctx, action, id, before, after)
+	if err != nil {
+		return err
 	}
-	if service != "" {
-		q += " AND service_name = $" + fmt.Sprint(len(args)+1)
-		args = append(args, service)
+	return repo.AddEvents(ctx, e)
+}
+
+// Purge hard deletes subscriptions soft deleted more than retention ago and
+// returns how many were removed.
+func (s *SubscriptionService) Purge(ctx context.Context, retention time.Duration) (_ int64, err error) {
+	ctx, end := startSpan(ctx, "SubscriptionService.Purge")
+	defer end(&err)
+
+	n, err := s.repo.Purge(ctx, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		s.log.Infof("purged %d deleted subscriptions", n)
 	}
-	var sum int
-	err := s.db.Get(&sum, q, args...)
-	return sum, err
+	return n, nil
+}
+
+// Stats counts the stored subscriptions, active ones in the current month.
+func (s *SubscriptionService) Stats(ctx context.Context) (_ *model.SubscriptionStats, err error) {
+	ctx, end := startSpan(ctx, "SubscriptionService.Stats")
+	defer end(&err)
+
+	now := time.Now().UTC()
+	return s.repo.Stats(ctx, time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC))
+}
+
+// Aggregate sums the costs selected by the filter in f.Currency, which
+// defaults to DefaultCurrency. Every cost is converted at the rate of its
+// month and the aggregate fails with MissingRatesError if any is missing.
+func (s *SubscriptionService) Aggregate(ctx context.Context, f model.AggregateFilter) (_ int, err error) {
+	ctx, end := startSpan(ctx, "SubscriptionService.Aggregate")
+	defer end(&err)
+
+	if err := s.checkRates(ctx, &f); err != nil {
+		return 0, err
+	}
+	return s.repo.Aggregate(ctx, f)
+}
+
+// AggregateMonthly breaks the prorated costs down by month, converted like
+// in Aggregate.
+func (s *SubscriptionService) AggregateMonthly(ctx context.Context, f model.AggregateFilter) (_ []model.MonthlyAggregate, err error) {
+	ctx, end := startSpan(ctx, "SubscriptionService.AggregateMonthly")
+	defer end(&err)
+
+	f.Mode = model.AggregateProrated
+	if err := s.checkRates(ctx, &f); err != nil {
+		return nil, err
+	}
+	return s.repo.AggregateMonthly(ctx, f)
+}
+
+// AggregateGroups breaks the costs down by f.GroupBy, converted like in
+// Aggregate.
+func (s *SubscriptionService) AggregateGroups(ctx context.Context, f model.AggregateFilter) (_ []model.AggregateGroup, err error) {
+	ctx, end := startSpan(ctx, "SubscriptionService.AggregateGroups")
+	defer end(&err)
+
+	if len(f.GroupBy) == 0 {
+		return nil, fmt.Errorf("%w: group by dimensions are required", ErrValidation)
+	}
+	if err := s.checkRates(ctx, &f); err != nil {
+		return nil, err
+	}
+	return s.repo.AggregateGroups(ctx, f)
+}
+
+// checkVersion reports ErrPreconditionFailed when the caller expects sub to
+// be at another version, zero matches any version.
+func checkVersion(sub *model.Subscription, version int64) error {
+	if version != 0 && sub.Version != version {
+		return fmt.Errorf("%w: expected version %d, current is %d", ErrPreconditionFailed, version, sub.Version)
+	}
+	return nil
+}
+
+// concurrentChange converts the version mismatch reported by the repository
+// when the subscription changed after it was read into ErrConflict, unless
+// the caller asked for a specific version.
+func concurrentChange(err error, version int64) error {
+	if version == 0 && errors.Is(err, ErrPreconditionFailed) {
+		return fmt.Errorf("%w: subscription was modified concurrently", ErrConflict)
+	}
+	return err
+}
+
+func validateID(id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalidID, id)
+	}
+	return nil
 }
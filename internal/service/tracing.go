@@ -0,0 +1,25 @@
+package service
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/DeneesK/sub-service/internal/service"
+
+// startSpan starts the span of a service method. The returned function ends
+// it and records the error the method returns, so it is deferred with the
+// address of the named error result.
+func startSpan(ctx context.Context, name string) (context.Context, func(err *error)) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, name, trace.WithSpanKind(trace.SpanKindInternal))
+	return ctx, func(err *error) {
+		if *err != nil {
+			span.RecordError(*err)
+			span.SetStatus(codes.Error, (*err).Error())
+		}
+		span.End()
+	}
+}
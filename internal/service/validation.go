@@ -0,0 +1,116 @@
+package service
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/DeneesK/sub-service/internal/model"
+	"github.com/google/uuid"
+)
+
+const (
+	maxServiceNameLen  = 255
+	maxBillingInterval = 100
+)
+
+// validateSubscription checks a complete subscription and reports every
+// rejected field at once.
+func validateSubscription(sub *model.Subscription) error {
+	var vErr ValidationError
+	switch {
+	case strings.TrimSpace(sub.ServiceName) == "":
+		vErr.Add("service_name", "must not be empty")
+	case utf8.RuneCountInString(sub.ServiceName) > maxServiceNameLen:
+		vErr.Add("service_name", "must be at most 255 characters long")
+	}
+	if sub.Price < 0 {
+		vErr.Add("price", "must not be negative")
+	}
+	if !model.IsCurrencyCode(sub.Currency) {
+		vErr.Add("currency", "must be an ISO 4217 currency code")
+	}
+	if sub.BillingPeriod.PerYear() == 0 {
+		vErr.Add("billing_period", "must be one of week, month, quarter, year")
+	}
+	if sub.BillingInterval < 1 || sub.BillingInterval > maxBillingInterval {
+		vErr.Add("billing_interval", "must be between 1 and 100")
+	}
+	if _, err := uuid.Parse(sub.UserID); err != nil {
+		vErr.Add("user_id", "must be a UUID")
+	}
+	if sub.StartDate.IsZero() {
+		vErr.Add("start_date", "is required")
+	}
+	if sub.EndDate != nil && !sub.StartDate.IsZero() && sub.EndDate.Before(sub.StartDate.Time) {
+		vErr.Add("end_date", "must not be before start_date")
+	}
+	return vErr.Err()
+}
+
+// validateUpdate rejects nulls for fields that cannot be removed and checks
+// the subscription that results from applying upd to its current state.
+func validateUpdate(current *model.Subscription, upd *model.UpdateSubscription) error {
+	var vErr ValidationError
+	for _, f := range []struct {
+		name string
+		null bool
+	}{
+		{"service_name", upd.ServiceName.Null},
+		{"price", upd.Price.Null},
+		{"user_id", upd.UserID.Null},
+		{"start_date", upd.StartDate.Null},
+	} {
+		if f.null {
+			vErr.Add(f.name, "must not be null")
+		}
+	}
+	if err := vErr.Err(); err != nil {
+		return err
+	}
+
+	merged := *current
+	upd.ApplyTo(&merged)
+	return validateSubscription(&merged)
+}
+
+// validateListFilter checks the filter and fills in the default limit, sort
+// and the decoded cursor.
+func validateListFilter(f *model.ListFilter) error {
+	var vErr ValidationError
+	if f.UserID != "" {
+		if _, err := uuid.Parse(f.UserID); err != nil {
+			vErr.Add("user_id", "must be a UUID")
+		}
+	}
+	switch {
+	case f.Limit == 0:
+		f.Limit = defaultListLimit
+	case f.Limit < 0 || f.Limit > maxListLimit:
+		vErr.Add("limit", "must be between 1 and 500")
+	}
+	switch f.Sort {
+	case "":
+		f.Sort = model.SortStartDateDesc
+	case model.SortStartDateAsc, model.SortStartDateDesc:
+	default:
+		vErr.Add("sort", "must be one of start_date, -start_date")
+	}
+	if f.PriceMin != nil && *f.PriceMin < 0 {
+		vErr.Add("price_min", "must not be negative")
+	}
+	if f.PriceMax != nil && f.PriceMin != nil && *f.PriceMax < *f.PriceMin {
+		vErr.Add("price_max", "must not be less than price_min")
+	}
+	if f.Cursor != "" {
+		after, err := decodeCursor(f.Cursor)
+		switch {
+		case err != nil:
+			vErr.Add("cursor", "is malformed")
+		case after.Sort != f.Sort:
+			vErr.Add("cursor", "was issued for a different sort order")
+		default:
+			f.After = after
+		}
+	}
+	return vErr.Err()
+}
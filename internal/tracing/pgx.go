@@ -0,0 +1,53 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/DeneesK/sub-service/internal/tracing"
+
+// QueryTracer records a client span with the statement of every query run
+// on a pgx connection. Arguments are left out, they may hold personal data.
+type QueryTracer struct{}
+
+var _ pgx.QueryTracer = QueryTracer{}
+
+func (QueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = otel.Tracer(tracerName).Start(ctx, queryOperation(data.SQL),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.statement", data.SQL),
+		),
+	)
+	return ctx
+}
+
+func (QueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	if data.Err != nil {
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+	} else {
+		span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	}
+	span.End()
+}
+
+// queryOperation names the span of a statement after its first keyword,
+// statements starting with a CTE are named after WITH.
+func queryOperation(sql string) string {
+	op, _, _ := strings.Cut(strings.TrimSpace(sql), " ")
+	op = strings.ToUpper(strings.TrimSpace(op))
+	if op == "" {
+		return "postgresql"
+	}
+	return "postgresql " + op
+}
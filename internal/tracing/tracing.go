@@ -0,0 +1,80 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Exporters a Config can select.
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+// Config selects where spans are sent.
+type Config struct {
+	// Exporter is one of ExporterNone, ExporterOTLP or ExporterStdout.
+	Exporter string
+	// Endpoint is the URL of the OTLP/HTTP collector, e.g.
+	// http://localhost:4318. The OTEL_EXPORTER_OTLP_* variables apply
+	// when it is empty.
+	Endpoint    string
+	ServiceName string
+	// SampleRatio is the share of traces started here that are recorded,
+	// traces started by a caller follow its sampling decision.
+	SampleRatio float64
+}
+
+// Setup installs the global tracer provider and the W3C trace context and
+// baggage propagators. The returned function flushes the spans left and has
+// to be called before exiting. Without an exporter spans are propagated but
+// not recorded.
+func Setup(ctx context.Context, conf Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch conf.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if conf.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(conf.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, expected %s, %s or %s",
+			conf.Exporter, ExporterNone, ExporterOTLP, ExporterStdout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create %s trace exporter: %w", conf.Exporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(),
+		resource.NewSchemaless(attribute.String("service.name", conf.ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(conf.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
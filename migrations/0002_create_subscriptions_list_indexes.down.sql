@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS subscriptions_user_id_start_date_id_idx;
+DROP INDEX IF EXISTS subscriptions_start_date_id_idx;
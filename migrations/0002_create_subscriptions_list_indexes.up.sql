@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS subscriptions_start_date_id_idx ON subscriptions (start_date, id);
+CREATE INDEX IF NOT EXISTS subscriptions_user_id_start_date_id_idx ON subscriptions (user_id, start_date, id);
@@ -0,0 +1,13 @@
+CREATE TABLE subscription_events (
+    id BIGSERIAL PRIMARY KEY,
+    subscription_id UUID NOT NULL,
+    action TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    request_id TEXT NOT NULL DEFAULT '',
+    before JSONB,
+    after JSONB,
+    diff JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX subscription_events_subscription_id_idx ON subscription_events (subscription_id, id);
@@ -0,0 +1,2 @@
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS billing_interval;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS billing_period;
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS currency_rates;
+
+UPDATE subscriptions SET price = price / 100;
+ALTER TABLE subscriptions
+    DROP COLUMN IF EXISTS currency,
+    ALTER COLUMN price TYPE INTEGER;
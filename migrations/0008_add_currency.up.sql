@@ -0,0 +1,13 @@
+-- Prices were whole rubles, they are kept in minor units of their currency now.
+ALTER TABLE subscriptions
+    ALTER COLUMN price TYPE BIGINT,
+    ADD COLUMN currency TEXT NOT NULL DEFAULT 'RUB' CHECK (currency ~ '^[A-Z]{3}$');
+UPDATE subscriptions SET price = price * 100;
+
+CREATE TABLE currency_rates (
+    currency TEXT NOT NULL CHECK (currency ~ '^[A-Z]{3}$'),
+    month DATE NOT NULL,
+    -- Price of one unit of the currency in rubles.
+    rate NUMERIC(20, 10) NOT NULL CHECK (rate > 0),
+    PRIMARY KEY (currency, month)
+);
@@ -0,0 +1,10 @@
+CREATE TABLE api_keys (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    -- SHA-256 of the key, the key itself is never stored.
+    key_hash TEXT NOT NULL UNIQUE,
+    roles TEXT[] NOT NULL CHECK (cardinality(roles) > 0 AND roles <@ ARRAY['reader', 'writer', 'admin']),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    revoked_at TIMESTAMPTZ
+);
@@ -0,0 +1,5 @@
+DELETE FROM idempotency_keys a USING idempotency_keys b
+    WHERE a.key = b.key AND a.subject > b.subject;
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (key);
+ALTER TABLE idempotency_keys DROP COLUMN subject;
@@ -0,0 +1,3 @@
+ALTER TABLE idempotency_keys ADD COLUMN subject TEXT NOT NULL DEFAULT '';
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (subject, key);